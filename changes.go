@@ -0,0 +1,68 @@
+package function
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+var clubhouseChangesKeys = getJSONKeys(reflect.TypeOf(ClubhouseChanges{}))
+
+func getJSONKeys(t reflect.Type) map[string]bool {
+	keys := make(map[string]bool)
+
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if key != "" && key != "-" {
+			keys[key] = true
+		}
+	}
+
+	return keys
+}
+
+// UnmarshalJSON decodes the modelled change keys, and captures the rest in Unknown.
+func (c *ClubhouseChanges) UnmarshalJSON(data []byte) error {
+	type clubhouseChanges ClubhouseChanges
+	if err := json.Unmarshal(data, (*clubhouseChanges)(c)); err != nil {
+		return err
+	}
+
+	var rawChanges map[string]json.RawMessage
+	if err := json.Unmarshal(data, &rawChanges); err != nil {
+		return err
+	}
+
+	c.Generic = make(map[string]ClubhouseGenericChange, len(rawChanges))
+
+	for key, value := range rawChanges {
+		var change ClubhouseGenericChange
+		if err := json.Unmarshal(value, &change); err != nil {
+			change = ClubhouseGenericChange{New: value}
+		}
+		c.Generic[key] = change
+
+		if clubhouseChangesKeys[key] {
+			continue
+		}
+
+		if c.Unknown == nil {
+			c.Unknown = make(map[string]json.RawMessage)
+		}
+		c.Unknown[key] = value
+	}
+
+	return nil
+}
+
+// getUnknownKeyNames returns the unmodelled change keys as human readable names, e.g. "Some Key".
+func getUnknownKeyNames(changes ClubhouseChanges) []string {
+	names := make([]string, 0, len(changes.Unknown))
+	for key := range changes.Unknown {
+		names = append(names, strings.Title(strings.ReplaceAll(key, "_", " ")))
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -1,16 +1,169 @@
 package function
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
 	"time"
 )
 
+const defaultClubhouseApiURL = "https://api.clubhouse.io/api/v3"
+
+var defaultClubhouseHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
 type ClubhouseApiClient struct {
 	ApiToken string
+	// Defaults to https://api.clubhouse.io/api/v3.
+	BaseURL string
+	// Defaults to a client with a 10 second timeout.
+	HTTPClient *http.Client
+	// How long entities resolved via the API are cached for. Defaults to 10 minutes.
+	CacheTTL time.Duration
+}
+
+// ClubhouseApiError is returned for responses with a non-2xx status code.
+type ClubhouseApiError struct {
+	Action     string
+	StatusCode int
+	Body       []byte
+}
+
+func (e *ClubhouseApiError) Error() string {
+	return fmt.Sprintf("failed to %s: %q (status code: %d)", e.Action, e.Body, e.StatusCode)
+}
+
+func (c *ClubhouseApiClient) getBaseURL() string {
+	if c.BaseURL != "" {
+		return c.BaseURL
+	}
+
+	return defaultClubhouseApiURL
+}
+
+// do sends a request to the API, and decodes the response into result. action describes the
+// request in errors, e.g. "get member".
+func (c *ClubhouseApiClient) do(action string, method string, apiURL string, body interface{}, result interface{}) error {
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = defaultClubhouseHTTPClient
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewBuffer(data)
+	}
+
+	req, err := http.NewRequest(method, apiURL, reqBody)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Clubhouse-Token", c.ApiToken)
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	if res.Body != nil {
+		defer res.Body.Close()
+	}
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return &ClubhouseApiError{Action: action, StatusCode: res.StatusCode, Body: data}
+	}
+
+	err = json.Unmarshal(data, result)
+	if err != nil {
+		log.Printf("\nraw data received: %q \n", data)
+		return err
+	}
+
+	return nil
+}
+
+func (c *ClubhouseApiClient) get(action string, path string, result interface{}) error {
+	return c.do(action, http.MethodGet, c.getBaseURL()+path, nil, result)
+}
+
+// https://clubhouse.io/api/rest/v3/#Pagination
+type paginatedResponse struct {
+	Data  json.RawMessage `json:"data"`
+	Next  *string         `json:"next"`
+	Total int             `json:"total"`
+}
+
+// getAllPages follows the "next" links of a paginated endpoint, calling onPage with the data of each page.
+// Endpoints that are not paginated (which respond with every result in an array) are a single page.
+func (c *ClubhouseApiClient) getAllPages(action string, path string, onPage func(data json.RawMessage) error) error {
+	baseURL, err := url.Parse(c.getBaseURL())
+	if err != nil {
+		return err
+	}
+
+	pageURL := c.getBaseURL() + path
+
+	for pageURL != "" {
+		var data json.RawMessage
+		err := c.do(action, http.MethodGet, pageURL, nil, &data)
+		if err != nil {
+			return err
+		}
+
+		var page paginatedResponse
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+			page.Data = data
+		} else if err := json.Unmarshal(data, &page); err != nil {
+			return err
+		}
+
+		err = onPage(page.Data)
+		if err != nil {
+			return err
+		}
+
+		pageURL = ""
+		if page.Next != nil && *page.Next != "" {
+			// The next link is relative to the API host, e.g. "/api/v3/search/stories?next=...".
+			nextURL, err := url.Parse(*page.Next)
+			if err != nil {
+				return err
+			}
+			pageURL = baseURL.ResolveReference(nextURL).String()
+		}
+	}
+
+	return nil
+}
+
+// list gets every result of a list or search endpoint, appending them to the slice that results points to.
+func (c *ClubhouseApiClient) list(action string, path string, results interface{}) error {
+	resultsValue := reflect.ValueOf(results).Elem()
+
+	return c.getAllPages(action, path, func(data json.RawMessage) error {
+		page := reflect.New(resultsValue.Type())
+		if err := json.Unmarshal(data, page.Interface()); err != nil {
+			return err
+		}
+		resultsValue.Set(reflect.AppendSlice(resultsValue, page.Elem()))
+		return nil
+	})
 }
 
 // https://clubhouse.io/api/rest/v3/#Get-Member
@@ -42,40 +195,442 @@ type GetMemberResponse struct {
 }
 
 func (c *ClubhouseApiClient) GetMember(memberPublicID string) (*GetMemberResponse, error) {
-	httpClient := http.Client{}
+	var memberRes GetMemberResponse
+	err := c.get("get member", fmt.Sprintf("/members/%s", url.PathEscape(memberPublicID)), &memberRes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &memberRes, nil
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Members
+func (c *ClubhouseApiClient) ListMembers() ([]GetMemberResponse, error) {
+	var membersRes []GetMemberResponse
+	err := c.list("list members", "/members", &membersRes)
+	return membersRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Get-Label
+type GetLabelResponse struct {
+	AppURL      string    `json:"app_url"`
+	Archived    bool      `json:"archived"`
+	Color       string    `json:"color"`
+	CreatedAt   time.Time `json:"created_at"`
+	Description string    `json:"description"`
+	EntityType  string    `json:"entity_type"`
+	ExternalID  string    `json:"external_id"`
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
 
-	apiURL := fmt.Sprintf("https://api.clubhouse.io/api/v3/members/%s", memberPublicID)
-	req, err := http.NewRequest(http.MethodGet, apiURL, nil)
+func (c *ClubhouseApiClient) GetLabel(labelPublicID int) (*GetLabelResponse, error) {
+	var labelRes GetLabelResponse
+	err := c.get("get label", fmt.Sprintf("/labels/%d", labelPublicID), &labelRes)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Clubhouse-Token", c.ApiToken)
+	return &labelRes, nil
+}
 
-	res, err := httpClient.Do(req)
+// https://clubhouse.io/api/rest/v3/#List-Labels
+func (c *ClubhouseApiClient) ListLabels() ([]GetLabelResponse, error) {
+	var labelsRes []GetLabelResponse
+	err := c.list("list labels", "/labels", &labelsRes)
+	return labelsRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Get-Story
+type GetStoryResponse struct {
+	AppURL          string                      `json:"app_url"`
+	Archived        bool                        `json:"archived"`
+	Blocked         bool                        `json:"blocked"`
+	Blocker         bool                        `json:"blocker"`
+	Completed       bool                        `json:"completed"`
+	CompletedAt     *time.Time                  `json:"completed_at"`
+	CreatedAt       time.Time                   `json:"created_at"`
+	CustomFields    []ClubhouseCustomFieldValue `json:"custom_fields"`
+	Deadline        *time.Time                  `json:"deadline"`
+	Description     string                      `json:"description"`
+	EntityType      string                      `json:"entity_type"`
+	EpicID          *int                        `json:"epic_id"`
+	Estimate        *int                        `json:"estimate"`
+	ID              int                         `json:"id"`
+	IterationID     *int                        `json:"iteration_id"`
+	LabelIds        []int                       `json:"label_ids"`
+	MovedAt         *time.Time                  `json:"moved_at"`
+	Name            string                      `json:"name"`
+	OwnerIds        []string                    `json:"owner_ids"`
+	ProjectID       int                         `json:"project_id"`
+	RequestedByID   string                      `json:"requested_by_id"`
+	Started         bool                        `json:"started"`
+	StartedAt       *time.Time                  `json:"started_at"`
+	StoryType       string                      `json:"story_type"`
+	UpdatedAt       time.Time                   `json:"updated_at"`
+	WorkflowStateID int                         `json:"workflow_state_id"`
+}
+
+func (c *ClubhouseApiClient) GetStory(storyPublicID int) (*GetStoryResponse, error) {
+	var storyRes GetStoryResponse
+	err := c.get("get story", fmt.Sprintf("/stories/%d", storyPublicID), &storyRes)
 	if err != nil {
 		return nil, err
 	}
-	if res.Body != nil {
-		defer res.Body.Close()
+
+	return &storyRes, nil
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Stories
+func (c *ClubhouseApiClient) ListStories(projectPublicID int) ([]GetStoryResponse, error) {
+	var storiesRes []GetStoryResponse
+	err := c.list("list stories", fmt.Sprintf("/projects/%d/stories", projectPublicID), &storiesRes)
+	return storiesRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Epic-Stories
+func (c *ClubhouseApiClient) ListEpicStories(epicPublicID int) ([]GetStoryResponse, error) {
+	var storiesRes []GetStoryResponse
+	err := c.list("list epic stories", fmt.Sprintf("/epics/%d/stories", epicPublicID), &storiesRes)
+	return storiesRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Search-Stories-Old
+type SearchStoriesParams struct {
+	Archived     *bool    `json:"archived,omitempty"`
+	IterationIds []int    `json:"iteration_ids,omitempty"`
+	OwnerIds     []string `json:"owner_ids,omitempty"`
+	ProjectIds   []int    `json:"project_ids,omitempty"`
+	// One of "unstarted", "started" or "done".
+	WorkflowStateTypes []string `json:"workflow_state_types,omitempty"`
+}
+
+// SearchStories finds stories by their attributes. This endpoint is not paginated, and responds with every story.
+func (c *ClubhouseApiClient) SearchStories(params SearchStoriesParams) ([]GetStoryResponse, error) {
+	var storiesRes []GetStoryResponse
+	err := c.do("search stories", http.MethodPost, c.getBaseURL()+"/stories/search", params, &storiesRes)
+	return storiesRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Search-Stories
+// The query uses Clubhouse's search operators, e.g. "owner:alice is:started". All pages of results are returned.
+func (c *ClubhouseApiClient) QueryStories(query string) ([]GetStoryResponse, error) {
+	var storiesRes []GetStoryResponse
+	path := "/search/stories?" + url.Values{"query": {query}, "page_size": {"25"}}.Encode()
+	err := c.list("search stories", path, &storiesRes)
+	return storiesRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Get-Epic
+type GetEpicResponse struct {
+	AppURL      string     `json:"app_url"`
+	Archived    bool       `json:"archived"`
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Deadline    *time.Time `json:"deadline"`
+	Description string     `json:"description"`
+	EntityType  string     `json:"entity_type"`
+	EpicStateID int        `json:"epic_state_id"`
+	FollowerIds []string   `json:"follower_ids"`
+	GroupID     *string    `json:"group_id"`
+	ID          int        `json:"id"`
+	MilestoneID *int       `json:"milestone_id"`
+	Name        string     `json:"name"`
+	OwnerIds    []string   `json:"owner_ids"`
+	ProjectIds  []int      `json:"project_ids"`
+	Started     bool       `json:"started"`
+	StartedAt   *time.Time `json:"started_at"`
+	// One of "to do", "in progress" or "done".
+	State string `json:"state"`
+	Stats struct {
+		NumPointsDone       int `json:"num_points_done"`
+		NumPointsStarted    int `json:"num_points_started"`
+		NumPointsUnstarted  int `json:"num_points_unstarted"`
+		NumStoriesDone      int `json:"num_stories_done"`
+		NumStoriesStarted   int `json:"num_stories_started"`
+		NumStoriesUnstarted int `json:"num_stories_unstarted"`
+	} `json:"stats"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (c *ClubhouseApiClient) GetEpic(epicPublicID int) (*GetEpicResponse, error) {
+	var epicRes GetEpicResponse
+	err := c.get("get epic", fmt.Sprintf("/epics/%d", epicPublicID), &epicRes)
+	if err != nil {
+		return nil, err
 	}
 
-	data, err := ioutil.ReadAll(res.Body)
+	return &epicRes, nil
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Epics
+func (c *ClubhouseApiClient) ListEpics() ([]GetEpicResponse, error) {
+	var epicsRes []GetEpicResponse
+	err := c.list("list epics", "/epics", &epicsRes)
+	return epicsRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Search-Epics
+// The query uses Clubhouse's search operators, e.g. "state:started". All pages of results are returned.
+func (c *ClubhouseApiClient) QueryEpics(query string) ([]GetEpicResponse, error) {
+	var epicsRes []GetEpicResponse
+	path := "/search/epics?" + url.Values{"query": {query}, "page_size": {"25"}}.Encode()
+	err := c.list("search epics", path, &epicsRes)
+	return epicsRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Workflow-State
+type WorkflowState struct {
+	Color       string    `json:"color"`
+	CreatedAt   time.Time `json:"created_at"`
+	Description string    `json:"description"`
+	EntityType  string    `json:"entity_type"`
+	ID          int       `json:"id"`
+	Name        string    `json:"name"`
+	NumStories  int       `json:"num_stories"`
+	Position    int       `json:"position"`
+	// One of "unstarted", "started" or "done".
+	Type      string    `json:"type"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Verb      *string   `json:"verb"`
+}
+
+// https://clubhouse.io/api/rest/v3/#Get-Workflow
+type GetWorkflowResponse struct {
+	CreatedAt      time.Time       `json:"created_at"`
+	DefaultStateID int             `json:"default_state_id"`
+	Description    string          `json:"description"`
+	EntityType     string          `json:"entity_type"`
+	ID             int             `json:"id"`
+	Name           string          `json:"name"`
+	ProjectIds     []int           `json:"project_ids"`
+	States         []WorkflowState `json:"states"`
+	TeamID         int             `json:"team_id"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+}
+
+func (c *ClubhouseApiClient) GetWorkflow(workflowPublicID int) (*GetWorkflowResponse, error) {
+	var workflowRes GetWorkflowResponse
+	err := c.get("get workflow", fmt.Sprintf("/workflows/%d", workflowPublicID), &workflowRes)
 	if err != nil {
 		return nil, err
 	}
 
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return nil, fmt.Errorf("failed to get member: %q (status code: %d)", data, res.StatusCode)
+	return &workflowRes, nil
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Workflows
+func (c *ClubhouseApiClient) ListWorkflows() ([]GetWorkflowResponse, error) {
+	var workflowsRes []GetWorkflowResponse
+	err := c.list("list workflows", "/workflows", &workflowsRes)
+	return workflowsRes, err
+}
+
+// GetWorkflowState finds a workflow state, and the workflow it belongs to. There is no endpoint for
+// workflow states, so this lists every workflow.
+func (c *ClubhouseApiClient) GetWorkflowState(workflowStatePublicID int) (*WorkflowState, *GetWorkflowResponse, error) {
+	workflows, err := c.ListWorkflows()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	var memberRes GetMemberResponse
-	err = json.Unmarshal(data, &memberRes)
+	for i := range workflows {
+		for j := range workflows[i].States {
+			if workflows[i].States[j].ID == workflowStatePublicID {
+				return &workflows[i].States[j], &workflows[i], nil
+			}
+		}
+	}
+
+	return nil, nil, &ClubhouseApiError{
+		Action:     "get workflow state",
+		StatusCode: http.StatusNotFound,
+		Body:       []byte(strconv.Itoa(workflowStatePublicID)),
+	}
+}
+
+// https://clubhouse.io/api/rest/v3/#Get-Project
+type GetProjectResponse struct {
+	AppURL          string    `json:"app_url"`
+	Archived        bool      `json:"archived"`
+	Color           string    `json:"color"`
+	CreatedAt       time.Time `json:"created_at"`
+	Description     string    `json:"description"`
+	EntityType      string    `json:"entity_type"`
+	FollowerIds     []string  `json:"follower_ids"`
+	ID              int       `json:"id"`
+	IterationLength int       `json:"iteration_length"`
+	Name            string    `json:"name"`
+	TeamID          int       `json:"team_id"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	WorkflowID      int       `json:"workflow_id"`
+}
+
+func (c *ClubhouseApiClient) GetProject(projectPublicID int) (*GetProjectResponse, error) {
+	var projectRes GetProjectResponse
+	err := c.get("get project", fmt.Sprintf("/projects/%d", projectPublicID), &projectRes)
 	if err != nil {
-		log.Printf("\nraw data received: %q \n", data)
 		return nil, err
 	}
 
-	return &memberRes, nil
+	return &projectRes, nil
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Projects
+func (c *ClubhouseApiClient) ListProjects() ([]GetProjectResponse, error) {
+	var projectsRes []GetProjectResponse
+	err := c.list("list projects", "/projects", &projectsRes)
+	return projectsRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Get-Iteration
+type GetIterationResponse struct {
+	AppURL    string    `json:"app_url"`
+	CreatedAt time.Time `json:"created_at"`
+	// Dates are formatted as YYYY-MM-DD.
+	EndDate    string    `json:"end_date"`
+	EntityType string    `json:"entity_type"`
+	GroupIds   []string  `json:"group_ids"`
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	StartDate  string    `json:"start_date"`
+	Status     string    `json:"status"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+func (c *ClubhouseApiClient) GetIteration(iterationPublicID int) (*GetIterationResponse, error) {
+	var iterationRes GetIterationResponse
+	err := c.get("get iteration", fmt.Sprintf("/iterations/%d", iterationPublicID), &iterationRes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &iterationRes, nil
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Iterations
+func (c *ClubhouseApiClient) ListIterations() ([]GetIterationResponse, error) {
+	var iterationsRes []GetIterationResponse
+	err := c.list("list iterations", "/iterations", &iterationsRes)
+	return iterationsRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Get-Milestone
+type GetMilestoneResponse struct {
+	AppURL      string     `json:"app_url"`
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Description string     `json:"description"`
+	EntityType  string     `json:"entity_type"`
+	ID          int        `json:"id"`
+	Name        string     `json:"name"`
+	Position    int        `json:"position"`
+	Started     bool       `json:"started"`
+	StartedAt   *time.Time `json:"started_at"`
+	// One of "to do", "in progress" or "done".
+	State     string    `json:"state"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (c *ClubhouseApiClient) GetMilestone(milestonePublicID int) (*GetMilestoneResponse, error) {
+	var milestoneRes GetMilestoneResponse
+	err := c.get("get milestone", fmt.Sprintf("/milestones/%d", milestonePublicID), &milestoneRes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &milestoneRes, nil
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Milestones
+func (c *ClubhouseApiClient) ListMilestones() ([]GetMilestoneResponse, error) {
+	var milestonesRes []GetMilestoneResponse
+	err := c.list("list milestones", "/milestones", &milestonesRes)
+	return milestonesRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#Get-Repository
+type GetRepositoryResponse struct {
+	CreatedAt  time.Time `json:"created_at"`
+	EntityType string    `json:"entity_type"`
+	ExternalID string    `json:"external_id"`
+	FullName   string    `json:"full_name"`
+	ID         int       `json:"id"`
+	Name       string    `json:"name"`
+	// e.g. "github".
+	Type      string    `json:"type"`
+	UpdatedAt time.Time `json:"updated_at"`
+	URL       string    `json:"url"`
+}
+
+func (c *ClubhouseApiClient) GetRepository(repositoryPublicID int) (*GetRepositoryResponse, error) {
+	var repositoryRes GetRepositoryResponse
+	err := c.get("get repository", fmt.Sprintf("/repositories/%d", repositoryPublicID), &repositoryRes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &repositoryRes, nil
+}
+
+// https://clubhouse.io/api/rest/v3/#Get-Group
+type GetGroupResponse struct {
+	AppURL      string   `json:"app_url"`
+	Archived    bool     `json:"archived"`
+	Color       string   `json:"color"`
+	Description string   `json:"description"`
+	EntityType  string   `json:"entity_type"`
+	ID          string   `json:"id"`
+	MemberIds   []string `json:"member_ids"`
+	MentionName string   `json:"mention_name"`
+	Name        string   `json:"name"`
+	WorkflowIds []int    `json:"workflow_ids"`
+}
+
+func (c *ClubhouseApiClient) GetGroup(groupPublicID string) (*GetGroupResponse, error) {
+	var groupRes GetGroupResponse
+	err := c.get("get group", fmt.Sprintf("/groups/%s", url.PathEscape(groupPublicID)), &groupRes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &groupRes, nil
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Groups
+func (c *ClubhouseApiClient) ListGroups() ([]GetGroupResponse, error) {
+	var groupsRes []GetGroupResponse
+	err := c.list("list groups", "/groups", &groupsRes)
+	return groupsRes, err
+}
+
+// https://clubhouse.io/api/rest/v3/#List-Custom-Fields
+type GetCustomFieldResponse struct {
+	CanonicalName string    `json:"canonical_name"`
+	CreatedAt     time.Time `json:"created_at"`
+	Description   string    `json:"description"`
+	Enabled       bool      `json:"enabled"`
+	EntityType    string    `json:"entity_type"`
+	FieldType     string    `json:"field_type"`
+	ID            string    `json:"id"`
+	Name          string    `json:"name"`
+	Position      int       `json:"position"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	Values        []struct {
+		ColorKey   string `json:"color_key"`
+		Enabled    bool   `json:"enabled"`
+		EntityType string `json:"entity_type"`
+		ID         string `json:"id"`
+		Position   int    `json:"position"`
+		Value      string `json:"value"`
+	} `json:"values"`
+}
+
+func (c *ClubhouseApiClient) ListCustomFields() ([]GetCustomFieldResponse, error) {
+	var customFieldsRes []GetCustomFieldResponse
+	err := c.list("list custom fields", "/custom-fields", &customFieldsRes)
+	return customFieldsRes, err
 }
@@ -0,0 +1,146 @@
+package function
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClubhouseApiClientErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Clubhouse-Token") != "token" {
+			t.Errorf("Clubhouse-Token = %q, want token", r.Header.Get("Clubhouse-Token"))
+		}
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"message": "Resource not found."}`))
+	}))
+	defer server.Close()
+
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: "token", BaseURL: server.URL}
+	_, err := clubhouseApiClient.GetStory(1)
+
+	apiErr, ok := err.(*ClubhouseApiError)
+	if !ok {
+		t.Fatalf("GetStory() = %v, want a ClubhouseApiError", err)
+	}
+	if apiErr.Action != "get story" || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("GetStory() = %+v", apiErr)
+	}
+}
+
+func TestQueryStoriesFollowsEveryPage(t *testing.T) {
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Clubhouse-Token") != "token" {
+			t.Errorf("Clubhouse-Token = %q, want token", r.Header.Get("Clubhouse-Token"))
+		}
+		requests = append(requests, r.URL.RequestURI())
+
+		switch r.URL.Query().Get("next") {
+		case "":
+			_, _ = w.Write([]byte(`{"data": [{"id": 1}, {"id": 2}], "next": "/api/v3/search/stories?next=a", "total": 5}`))
+		case "a":
+			_, _ = w.Write([]byte(`{"data": [{"id": 3}, {"id": 4}], "next": "/api/v3/search/stories?next=b", "total": 5}`))
+		default:
+			_, _ = w.Write([]byte(`{"data": [{"id": 5}], "next": null, "total": 5}`))
+		}
+	}))
+	defer server.Close()
+
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: "token", BaseURL: server.URL + "/api/v3"}
+	stories, err := clubhouseApiClient.QueryStories("is:started")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for _, story := range stories {
+		ids = append(ids, fmt.Sprint(story.ID))
+	}
+	if strings.Join(ids, ",") != "1,2,3,4,5" {
+		t.Errorf("QueryStories() = %v, want every page of stories", ids)
+	}
+
+	wantRequests := []string{
+		"/api/v3/search/stories?page_size=25&query=is%3Astarted",
+		"/api/v3/search/stories?next=a",
+		"/api/v3/search/stories?next=b",
+	}
+	if strings.Join(requests, " ") != strings.Join(wantRequests, " ") {
+		t.Errorf("requests = %v, want %v", requests, wantRequests)
+	}
+}
+
+func TestListEndpointsAreASinglePage(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/workflows" {
+			t.Errorf("path = %q, want /workflows", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`[
+			{"id": 1, "name": "Engineering", "states": [{"id": 500, "name": "In Review"}]},
+			{"id": 2, "name": "Design", "states": [{"id": 600, "name": "Ready"}]}
+		]`))
+	}))
+	defer server.Close()
+
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: "token", BaseURL: server.URL}
+
+	workflows, err := clubhouseApiClient.ListWorkflows()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(workflows) != 2 || requests != 1 {
+		t.Errorf("ListWorkflows() = %+v in %d requests, want 2 workflows in 1", workflows, requests)
+	}
+
+	state, workflow, err := clubhouseApiClient.GetWorkflowState(600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Name != "Ready" || workflow.Name != "Design" {
+		t.Errorf("GetWorkflowState() = %+v, %+v, want Ready in Design", state, workflow)
+	}
+
+	_, _, err = clubhouseApiClient.GetWorkflowState(700)
+	if apiErr, ok := err.(*ClubhouseApiError); !ok || apiErr.StatusCode != http.StatusNotFound {
+		t.Errorf("GetWorkflowState() = %v, want a not found ClubhouseApiError", err)
+	}
+}
+
+func TestClubhouseApiClientTimesOut(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	timeout := defaultClubhouseHTTPClient.Timeout
+	defaultClubhouseHTTPClient.Timeout = 50 * time.Millisecond
+	defer func() { defaultClubhouseHTTPClient.Timeout = timeout }()
+
+	// Without an HTTP client of its own, the client uses the default one, and its timeout.
+	start := time.Now()
+	_, err := (&ClubhouseApiClient{ApiToken: "token", BaseURL: server.URL}).GetStory(1)
+	if err == nil || time.Since(start) > 2*time.Second {
+		t.Errorf("GetStory() = %v after %s, want it to time out", err, time.Since(start))
+	}
+
+	// An HTTP client of its own is used instead.
+	httpClient := &http.Client{Timeout: 100 * time.Millisecond, Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(`{"id": 1}`)), Request: r}, nil
+	})}
+	story, err := (&ClubhouseApiClient{ApiToken: "token", BaseURL: server.URL, HTTPClient: httpClient}).GetStory(1)
+	if err != nil || story.ID != 1 {
+		t.Errorf("GetStory() = %+v, %v, want the story from the HTTP client", story, err)
+	}
+}
@@ -0,0 +1,102 @@
+// Command server runs the function as a standalone HTTP server, for deployments outside of
+// Google Cloud Functions. It is configured with the same environment variables.
+package main
+
+import (
+	"crypto/subtle"
+	"expvar"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	function "github.com/Courtsite/clubhouse-to-discord"
+)
+
+func main() {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	if err := function.LoadEnvironments(); err != nil {
+		log.Fatalln(err)
+	}
+
+	flushInterval := 10 * time.Second
+	if rawFlushInterval := os.Getenv("FLUSH_INTERVAL"); rawFlushInterval != "" {
+		var err error
+		flushInterval, err = time.ParseDuration(rawFlushInterval)
+		if err != nil {
+			log.Fatalln("`FLUSH_INTERVAL` is not a valid duration:", err)
+		}
+	}
+
+	go func() {
+		for range time.Tick(flushInterval) {
+			function.FlushCoalesced()
+			function.FlushSinks()
+		}
+	}()
+
+	go func() {
+		for range time.Tick(time.Minute) {
+			function.PostDueDigests("")
+			function.PostStandupIfDue()
+		}
+	}()
+
+	// Metrics (e.g. rejected replays) are only served on METRICS_ADDR, which should not be public.
+	if metricsAddr := os.Getenv("METRICS_ADDR"); metricsAddr != "" {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/debug/vars", expvar.Handler())
+
+		go func() {
+			log.Println("serving metrics on", metricsAddr)
+			log.Fatalln(http.ListenAndServe(metricsAddr, metricsMux))
+		}()
+	}
+
+	triggerToken := os.Getenv("TRIGGER_TOKEN")
+	if triggerToken == "" {
+		log.Println("`TRIGGER_TOKEN` is not set, so /flush, /digest and /standup are disabled")
+	}
+
+	log.Println("listening on port", port)
+	log.Fatalln(http.ListenAndServe(":"+port, newMux(triggerToken)))
+}
+
+// newMux routes webhooks, and the endpoints that trigger flushes, digests and standups, which require the
+// trigger token. They are left out without one, as the server triggers them itself.
+func newMux(triggerToken string) *http.ServeMux {
+	// Not http.DefaultServeMux, which expvar adds /debug/vars to.
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", function.F)
+	mux.HandleFunc("/hooks/", function.F)
+
+	if triggerToken != "" {
+		mux.Handle("/flush", requireToken(triggerToken, function.Flush))
+		mux.Handle("/digest", requireToken(triggerToken, function.Digest))
+		mux.Handle("/standup", requireToken(triggerToken, function.Standup))
+	}
+
+	return mux
+}
+
+// requireToken only calls handler for requests with an "Authorization: Bearer <token>" header.
+func requireToken(token string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+		if !strings.HasPrefix(authorization, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, "Bearer ")), []byte(token)) != 1 {
+			log.Printf("\nunauthorized request: %s %s \n", r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("unauthorized"))
+			return
+		}
+
+		handler(w, r)
+	})
+}
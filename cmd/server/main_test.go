@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTriggerEndpointsRequireTheToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		triggerToken  string
+		path          string
+		authorization string
+		want          int
+	}{
+		{"no token", "secret", "/flush", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "/digest", "Bearer guess", http.StatusUnauthorized},
+		{"not a bearer token", "secret", "/standup", "secret", http.StatusUnauthorized},
+		// Flush rejects GET requests, so reaching it is a 400 rather than posting anything.
+		{"token", "secret", "/flush", "Bearer secret", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.authorization != "" {
+				r.Header.Set("Authorization", test.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			newMux(test.triggerToken).ServeHTTP(w, r)
+
+			if w.Code != test.want {
+				t.Errorf("%s = %d %q, want %d", test.path, w.Code, w.Body.String(), test.want)
+			}
+		})
+	}
+}
+
+func TestTriggerEndpointsAndMetricsAreNotPublic(t *testing.T) {
+	tests := []struct {
+		triggerToken string
+		path         string
+		want         string
+	}{
+		{"secret", "/flush", "/flush"},
+		{"", "/flush", "/"},
+		{"", "/digest", "/"},
+		{"", "/standup", "/"},
+		{"secret", "/debug/vars", "/"},
+	}
+
+	for _, test := range tests {
+		// Anything routed to "/" is handled as a webhook.
+		_, pattern := newMux(test.triggerToken).Handler(httptest.NewRequest(http.MethodPost, test.path, nil))
+		if pattern != test.want {
+			t.Errorf("%s with trigger token %q is routed to %q, want %q", test.path, test.triggerToken, pattern, test.want)
+		}
+	}
+}
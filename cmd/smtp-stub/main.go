@@ -0,0 +1,48 @@
+// Command smtp-stub is a local stand-in for an SMTP server, to try the email sink without sending email.
+// It accepts every message (and any credentials), prints it, and saves it as a .eml file when a directory
+// is given.
+//
+//	smtp-stub [-addr localhost:2525] [-dir ./mail]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Courtsite/clubhouse-to-discord/internal/smtpstub"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:2525", "address to listen on")
+	dir := flag.String("dir", "", "directory to save messages to")
+	flag.Parse()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	log.Println("listening on", listener.Addr())
+	log.Fatalln(smtpstub.Serve(listener, func(message smtpstub.Message) {
+		receive(message, *dir)
+	}))
+}
+
+func receive(message smtpstub.Message, dir string) {
+	log.Printf("\nfrom: %s\nto: %s\n\n%s\n", message.From, strings.Join(message.To, ", "), message.Data)
+
+	if dir == "" {
+		return
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d.eml", time.Now().UnixNano()))
+	if err := ioutil.WriteFile(path, []byte(message.Data), 0600); err != nil {
+		log.Println("failed to save message:", err)
+	}
+}
@@ -0,0 +1,32 @@
+// Command validate-config checks a configuration file, or the environment variables when no file is given,
+// and reports every problem with it.
+//
+//	validate-config [config.json|config.yaml|config.toml]
+package main
+
+import (
+	"fmt"
+	"os"
+
+	function "github.com/Courtsite/clubhouse-to-discord"
+)
+
+func main() {
+	var err error
+	if len(os.Args) > 1 {
+		var config *function.Config
+		config, err = function.LoadConfig(os.Args[1])
+		if err == nil {
+			err = function.ValidateConfig(config)
+		}
+	} else {
+		err = function.ValidateCurrentConfig()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	fmt.Println("configuration is valid")
+}
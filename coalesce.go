@@ -0,0 +1,349 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A CoalesceStore buffers successive webhooks for the same entity, so they can be posted together.
+type CoalesceStore interface {
+	Add(key string, data []byte, receivedAt time.Time) error
+	// TakeQuiet removes and returns the buffer of every key that has not received a webhook since quietSince.
+	TakeQuiet(quietSince time.Time) ([]CoalesceBuffer, error)
+	// Restore puts back a buffer that failed to be posted, before any webhooks received since it was taken.
+	Restore(buffer CoalesceBuffer) error
+}
+
+// A CoalesceBuffer is the webhooks received for a key, oldest first.
+type CoalesceBuffer struct {
+	Key        string
+	ReceivedAt time.Time
+	Webhooks   [][]byte
+}
+
+// FileCoalesceStore stores buffers as files in a directory. To coalesce across multiple instances,
+// the directory must be on storage that is shared between them.
+type FileCoalesceStore struct {
+	Dir string
+}
+
+type coalesceBuffer struct {
+	ReceivedAt time.Time         `json:"received_at"`
+	Webhooks   []json.RawMessage `json:"webhooks"`
+}
+
+func (s *FileCoalesceStore) getPath(key string) string {
+	return filepath.Join(s.Dir, key+".json")
+}
+
+func (s *FileCoalesceStore) read(key string) (coalesceBuffer, error) {
+	var buffer coalesceBuffer
+
+	bufferData, err := ioutil.ReadFile(s.getPath(key))
+	if os.IsNotExist(err) {
+		return buffer, nil
+	}
+	if err != nil {
+		return buffer, err
+	}
+
+	err = json.Unmarshal(bufferData, &buffer)
+	return buffer, err
+}
+
+func (s *FileCoalesceStore) write(key string, buffer coalesceBuffer) error {
+	bufferData, err := json.Marshal(buffer)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.getPath(key), bufferData)
+}
+
+func (s *FileCoalesceStore) Add(key string, data []byte, receivedAt time.Time) error {
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	buffer, err := s.read(key)
+	if err != nil {
+		return err
+	}
+
+	buffer.ReceivedAt = receivedAt
+	buffer.Webhooks = append(buffer.Webhooks, json.RawMessage(data))
+
+	return s.write(key, buffer)
+}
+
+func (s *FileCoalesceStore) Restore(restored CoalesceBuffer) error {
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	buffer, err := s.read(restored.Key)
+	if err != nil {
+		return err
+	}
+
+	webhooks := make([]json.RawMessage, 0, len(restored.Webhooks)+len(buffer.Webhooks))
+	for _, webhook := range restored.Webhooks {
+		webhooks = append(webhooks, json.RawMessage(webhook))
+	}
+	buffer.Webhooks = append(webhooks, buffer.Webhooks...)
+
+	// Webhooks received since keep the buffer from being quiet.
+	if restored.ReceivedAt.After(buffer.ReceivedAt) {
+		buffer.ReceivedAt = restored.ReceivedAt
+	}
+
+	return s.write(restored.Key, buffer)
+}
+
+func (s *FileCoalesceStore) TakeQuiet(quietSince time.Time) ([]CoalesceBuffer, error) {
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	bufferPaths, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(bufferPaths)
+
+	var quietBuffers []CoalesceBuffer
+
+	for _, bufferPath := range bufferPaths {
+		bufferData, err := ioutil.ReadFile(bufferPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var buffer coalesceBuffer
+		if err := json.Unmarshal(bufferData, &buffer); err != nil {
+			return nil, err
+		}
+
+		if buffer.ReceivedAt.After(quietSince) {
+			continue
+		}
+
+		webhooks := make([][]byte, len(buffer.Webhooks))
+		for i, webhook := range buffer.Webhooks {
+			webhooks[i] = webhook
+		}
+		quietBuffers = append(quietBuffers, CoalesceBuffer{
+			Key:        strings.TrimSuffix(filepath.Base(bufferPath), ".json"),
+			ReceivedAt: buffer.ReceivedAt,
+			Webhooks:   webhooks,
+		})
+
+		if err := os.Remove(bufferPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return quietBuffers, nil
+}
+
+// isCoalescable reports whether a webhook is a plain story update, which can be merged with others.
+func isCoalescable(webhook ClubhouseWebhook) bool {
+	return webhook.PrimaryID > 0 &&
+		len(webhook.Actions) == 1 &&
+		webhook.Actions[0].EntityType == "story" &&
+		webhook.Actions[0].Action == "update"
+}
+
+// mergeWebhooks merges successive updates of the same story into one webhook, with the changes
+// going from the oldest value to the newest.
+func mergeWebhooks(webhooksData [][]byte) (ClubhouseWebhook, error) {
+	var merged ClubhouseWebhook
+	var mergedChanges map[string]ClubhouseGenericChange
+	referencesByTypeID := make(map[string]ClubhouseReference)
+
+	for i, data := range webhooksData {
+		var webhook ClubhouseWebhook
+		if err := json.Unmarshal(data, &webhook); err != nil {
+			return ClubhouseWebhook{}, err
+		}
+
+		if i == 0 {
+			mergedChanges = webhook.Actions[0].Changes.Generic
+		} else {
+			mergedChanges = mergeGenericChanges(mergedChanges, webhook.Actions[0].Changes.Generic)
+		}
+
+		for typeID, reference := range getReferencesByTypeID(webhook) {
+			referencesByTypeID[typeID] = reference
+		}
+
+		merged = webhook
+	}
+
+	changesData, err := json.Marshal(mergedChanges)
+	if err != nil {
+		return ClubhouseWebhook{}, err
+	}
+
+	var changes ClubhouseChanges
+	if err := json.Unmarshal(changesData, &changes); err != nil {
+		return ClubhouseWebhook{}, err
+	}
+	merged.Actions[0].Changes = changes
+
+	merged.References = make([]ClubhouseReference, 0, len(referencesByTypeID))
+	for _, reference := range referencesByTypeID {
+		merged.References = append(merged.References, reference)
+	}
+
+	return merged, nil
+}
+
+func mergeGenericChanges(older map[string]ClubhouseGenericChange, newer map[string]ClubhouseGenericChange) map[string]ClubhouseGenericChange {
+	merged := make(map[string]ClubhouseGenericChange, len(older)+len(newer))
+	for key, change := range older {
+		merged[key] = change
+	}
+
+	for key, newerChange := range newer {
+		olderChange, ok := merged[key]
+		if !ok {
+			merged[key] = newerChange
+			continue
+		}
+
+		// Values that were added and then removed (or the other way around) cancel out. A missing old or new
+		// value means the field was empty, e.g. a deadline being cleared only has an old value.
+		change := ClubhouseGenericChange{
+			Old: olderChange.Old,
+			New: newerChange.New,
+			Adds: append(
+				subtractRawValues(olderChange.Adds, newerChange.Removes),
+				subtractRawValues(newerChange.Adds, olderChange.Removes)...,
+			),
+			Removes: append(
+				subtractRawValues(olderChange.Removes, newerChange.Adds),
+				subtractRawValues(newerChange.Removes, olderChange.Adds)...,
+			),
+		}
+		// Values that were changed and then changed back are left out.
+		if len(change.Adds) == 0 && len(change.Removes) == 0 && bytes.Equal(getRawValueOrNull(change.Old), getRawValueOrNull(change.New)) {
+			delete(merged, key)
+			continue
+		}
+
+		merged[key] = change
+	}
+
+	return merged
+}
+
+func getRawValueOrNull(value json.RawMessage) json.RawMessage {
+	if len(value) == 0 {
+		return json.RawMessage("null")
+	}
+
+	return value
+}
+
+func subtractRawValues(values []json.RawMessage, subtract []json.RawMessage) []json.RawMessage {
+	subtracted := make(map[string]bool, len(subtract))
+	for _, value := range subtract {
+		subtracted[string(value)] = true
+	}
+
+	var result []json.RawMessage
+	seen := make(map[string]bool, len(values))
+	for _, value := range values {
+		if subtracted[string(value)] || seen[string(value)] {
+			continue
+		}
+		seen[string(value)] = true
+		result = append(result, value)
+	}
+
+	return result
+}
+
+// flushCoalescedWebhooks posts the merged updates of every story that has been quiet for the coalesce window.
+func flushCoalescedWebhooks(env environment, clubhouseApiClient *ClubhouseApiClient, coalesceStore CoalesceStore) {
+	quietBuffers, err := coalesceStore.TakeQuiet(time.Now().Add(-env.CoalesceWindow))
+	if err != nil {
+		log.Println("failed to take coalesced webhooks:", err)
+		return
+	}
+
+	for _, buffer := range quietBuffers {
+		data := []byte(fmt.Sprintf("[%s]", bytes.Join(buffer.Webhooks, []byte(","))))
+
+		// Webhooks that cannot be merged never will be, so they are not put back.
+		webhook, err := mergeWebhooks(buffer.Webhooks)
+		if err != nil {
+			log.Printf("\nraw data received: %q \n", data)
+			log.Println("failed to merge coalesced webhooks:", err)
+			continue
+		}
+
+		// Sinks that failed while others succeeded are not retried here, as that would post to the others twice.
+		_, sinkResults, err := forwardWebhook(env, clubhouseApiClient, webhook, data)
+		if err == nil && len(sinkResults) > 0 && getDeliveryStatusCode(sinkResults) == http.StatusBadGateway {
+			err = fmt.Errorf("failed to send to every sink")
+		}
+		if err != nil {
+			log.Printf("\nraw data received: %q \n", data)
+			log.Println("failed to forward coalesced webhooks, they will be retried:", err)
+
+			if err := coalesceStore.Restore(buffer); err != nil {
+				log.Println("failed to put back coalesced webhooks:", err)
+			}
+		}
+	}
+}
+
+// FlushCoalesced posts coalesced story updates that are due.
+func FlushCoalesced() {
+	for _, env := range getEnvironments() {
+		if env.CoalesceWindow <= 0 {
+			continue
+		}
+
+		clubhouseApiClient := env.newClubhouseApiClient()
+		flushCoalescedWebhooks(env, clubhouseApiClient, &FileCoalesceStore{Dir: env.CoalesceStoreDir})
+	}
+}
+
+// Flush is an HTTP entry point for FlushCoalesced and FlushSinks, to be triggered periodically (e.g. by Cloud
+// Scheduler), so that updates are posted even when no further webhooks arrive.
+func Flush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		log.Printf("\ninvalid method: %s \n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("invalid request"))
+		return
+	}
+
+	FlushCoalesced()
+	FlushSinks()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// getCoalesceKey returns a file name safe key for the coalesce buffer of a webhook.
+func getCoalesceKey(webhook ClubhouseWebhook) string {
+	return fmt.Sprintf("%s-%d", webhook.Actions[0].EntityType, webhook.PrimaryID)
+}
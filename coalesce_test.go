@@ -0,0 +1,160 @@
+package function
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMergeGenericChanges(t *testing.T) {
+	tests := []struct {
+		name   string
+		older  string
+		newer  string
+		merged string
+	}{
+		{
+			name:   "changed twice",
+			older:  `{"estimate": {"old": 1, "new": 2}}`,
+			newer:  `{"estimate": {"old": 2, "new": 3}}`,
+			merged: `{"estimate": {"old": 1, "new": 3}}`,
+		},
+		{
+			name:   "deadline cleared",
+			older:  `{"deadline": {"old": "2021-01-01T00:00:00Z", "new": "2021-02-01T00:00:00Z"}}`,
+			newer:  `{"deadline": {"old": "2021-02-01T00:00:00Z"}}`,
+			merged: `{"deadline": {"old": "2021-01-01T00:00:00Z"}}`,
+		},
+		{
+			name:   "epic set then cleared",
+			older:  `{"epic_id": {"new": 1}}`,
+			newer:  `{"epic_id": {"old": 1}}`,
+			merged: `{}`,
+		},
+		{
+			name:   "epic set then cleared with null",
+			older:  `{"epic_id": {"old": null, "new": 1}}`,
+			newer:  `{"epic_id": {"old": 1, "new": null}}`,
+			merged: `{}`,
+		},
+		{
+			name:   "changed back",
+			older:  `{"estimate": {"old": 1, "new": 2}}`,
+			newer:  `{"estimate": {"old": 2, "new": 1}}`,
+			merged: `{}`,
+		},
+		{
+			name:   "label added then removed",
+			older:  `{"label_ids": {"adds": [1, 2]}}`,
+			newer:  `{"label_ids": {"removes": [1]}}`,
+			merged: `{"label_ids": {"adds": [2]}}`,
+		},
+		{
+			name:   "other fields",
+			older:  `{"estimate": {"old": 1, "new": 2}}`,
+			newer:  `{"name": {"old": "a", "new": "b"}}`,
+			merged: `{"estimate": {"old": 1, "new": 2}, "name": {"old": "a", "new": "b"}}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			merged := mergeGenericChanges(parseGenericChanges(t, test.older), parseGenericChanges(t, test.newer))
+			if want := parseGenericChanges(t, test.merged); !reflect.DeepEqual(normalizeGenericChanges(t, merged), normalizeGenericChanges(t, want)) {
+				data, _ := json.Marshal(merged)
+				t.Errorf("mergeGenericChanges() = %s, want %s", data, test.merged)
+			}
+		})
+	}
+}
+
+func parseGenericChanges(t *testing.T, data string) map[string]ClubhouseGenericChange {
+	var changes map[string]ClubhouseGenericChange
+	if err := json.Unmarshal([]byte(data), &changes); err != nil {
+		t.Fatal(err)
+	}
+
+	return changes
+}
+
+// normalizeGenericChanges round trips changes through JSON, so that they can be compared regardless of spacing.
+func normalizeGenericChanges(t *testing.T, changes map[string]ClubhouseGenericChange) interface{} {
+	data, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		t.Fatal(err)
+	}
+
+	return normalized
+}
+
+func TestFileCoalesceStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coalesce")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &FileCoalesceStore{Dir: dir}
+	now := time.Now()
+
+	if err := store.Add("story-1", []byte(`{"id": "a"}`), now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("story-1", []byte(`{"id": "b"}`), now.Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add("story-2", []byte(`{"id": "c"}`), now); err != nil {
+		t.Fatal(err)
+	}
+
+	buffers, err := store.TakeQuiet(now.Add(-30 * time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buffers) != 1 || buffers[0].Key != "story-1" || len(buffers[0].Webhooks) != 2 {
+		t.Fatalf("TakeQuiet() = %+v, want the 2 webhooks of story-1", buffers)
+	}
+
+	// A webhook received while the buffer was being posted stays after the restored ones.
+	if err := store.Add("story-1", []byte(`{"id": "d"}`), now); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Restore(buffers[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	if buffers, err := store.TakeQuiet(now.Add(-30 * time.Second)); err != nil || len(buffers) != 0 {
+		t.Fatalf("TakeQuiet() = %+v, %v, want nothing quiet", buffers, err)
+	}
+
+	buffers, err = store.TakeQuiet(now.Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, buffer := range buffers {
+		if buffer.Key != "story-1" {
+			continue
+		}
+		for _, webhook := range buffer.Webhooks {
+			var webhookID struct {
+				ID string `json:"id"`
+			}
+			if err := json.Unmarshal(webhook, &webhookID); err != nil {
+				t.Fatal(err)
+			}
+			ids = append(ids, webhookID.ID)
+		}
+	}
+	if want := []string{"a", "b", "d"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("webhooks of story-1 = %v, want %v", ids, want)
+	}
+}
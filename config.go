@@ -0,0 +1,688 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the format of the configuration file (CONFIG_FILE). Without a file, the same settings are read
+// from environment variables, which are noted next to each field.
+type Config struct {
+	Discord   DiscordConfig   `json:"discord"`
+	Clubhouse ClubhouseConfig `json:"clubhouse"`
+	Filters   FiltersConfig   `json:"filters"`
+	Routes    RoutesConfig    `json:"routes"`
+	// Other places (e.g. Slack) every forwarded event is also sent to.
+	Sinks     []SinkConfig    `json:"sinks"` // SINKS
+	Replay    StoreConfig     `json:"replay"`
+	Coalesce  StoreConfig     `json:"coalesce"`
+	Digests   DigestsConfig   `json:"digests"`
+	Standup   StandupConfig   `json:"standup"`
+	Secrets   SecretsConfig   `json:"secrets"`
+	Templates TemplatesConfig `json:"templates"`
+	Caches    CachesConfig    `json:"caches"`
+	// Where coalesced webhooks, digests, replay nonces and email batches are kept, unless they have their own
+	// store_dir. Tenants without one use "tenants/{tenant}" in the top level one.
+	StoreDir string `json:"store_dir"` // STORE_DIR
+
+	// Other workspaces, each with its own settings, selected by the /hooks/{tenant} path or a ?token= query
+	// parameter. Tenants without secrets settings use the top level ones.
+	Tenants map[string]Config `json:"tenants,omitempty"`
+	// Only used by tenants, to select them with ?token= (for webhooks that cannot use the path).
+	Token string `json:"token,omitempty"`
+}
+
+type DiscordConfig struct {
+	WebhookURL              string                        `json:"webhook_url"`                // DISCORD_WEBHOOK_URL
+	EscalationWebhookURL    string                        `json:"escalation_webhook_url"`     // DISCORD_ESCALATION_WEBHOOK_URL
+	TimestampMarkup         *bool                         `json:"timestamp_markup"`           // DISCORD_TIMESTAMP_MARKUP
+	DeadlineTimezone        string                        `json:"deadline_timezone"`          // DEADLINE_TIMEZONE
+	DeadlineFormat          string                        `json:"deadline_format"`            // DEADLINE_FORMAT
+	RenderUnknownEvents     bool                          `json:"render_unknown_events"`      // RENDER_UNKNOWN_EVENTS
+	SuppressVCSStateChanges bool                          `json:"suppress_vcs_state_changes"` // SUPPRESS_VCS_STATE_CHANGES
+	ShowPositionChanges     bool                          `json:"show_position_changes"`      // SHOW_POSITION_CHANGES
+	WorkflowStateStyles     map[string]WorkflowStateStyle `json:"workflow_state_styles"`      // WORKFLOW_STATE_STYLES
+	// How Discord is retried, as for sinks.
+	Timeout      string `json:"timeout,omitempty"`       // DISCORD_TIMEOUT
+	Retries      *int   `json:"retries,omitempty"`       // DISCORD_RETRIES
+	RetryBackoff string `json:"retry_backoff,omitempty"` // DISCORD_RETRY_BACKOFF
+}
+
+type ClubhouseConfig struct {
+	ApiToken       string   `json:"api_token"`       // CLUBHOUSE_API_TOKEN
+	WebhookSecrets []string `json:"webhook_secrets"` // CLUBHOUSE_WEBHOOK_SECRET (comma separated)
+	WebhookStrict  *bool    `json:"webhook_strict"`  // CLUBHOUSE_WEBHOOK_STRICT
+}
+
+type FiltersConfig struct {
+	CustomFields []CustomFieldRule `json:"custom_fields"` // CUSTOM_FIELD_FILTERS
+}
+
+type RoutesConfig struct {
+	CustomFields []CustomFieldRule `json:"custom_fields"` // CUSTOM_FIELD_ROUTES
+}
+
+type StoreConfig struct {
+	// A duration, e.g. "30s".
+	Window   string `json:"window"`    // REPLAY_WINDOW, COALESCE_WINDOW
+	StoreDir string `json:"store_dir"` // REPLAY_STORE_DIR, COALESCE_STORE_DIR
+}
+
+type DigestsConfig struct {
+	Channels []DigestChannel `json:"channels"`  // DIGEST_CHANNELS
+	StoreDir string          `json:"store_dir"` // DIGEST_STORE_DIR
+}
+
+type StandupConfig struct {
+	WebhookURL string `json:"webhook_url"` // STANDUP_WEBHOOK_URL
+	Schedule   string `json:"schedule"`    // STANDUP_SCHEDULE
+	Timezone   string `json:"timezone"`    // STANDUP_TIMEZONE
+}
+
+type TemplatesConfig struct {
+	// A Go text/template for embed titles, given .Actor (empty without a member), .Verb, .EntityType and .Name.
+	Title string `json:"title"` // TITLE_TEMPLATE
+}
+
+type CachesConfig struct {
+	// How long entities resolved via the API (e.g. projects and workflows) are cached for. A duration, e.g. "10m".
+	ReferenceTTL string `json:"reference_ttl"` // REFERENCE_CACHE_TTL
+}
+
+type SecretsConfig struct {
+	Provider        string `json:"provider"`          // SECRETS_PROVIDER
+	Dir             string `json:"dir"`               // SECRETS_DIR
+	File            string `json:"file"`              // SECRETS_FILE
+	CacheTTL        string `json:"cache_ttl"`         // SECRETS_CACHE_TTL
+	GCPProject      string `json:"gcp_project"`       // GCP_PROJECT
+	VaultAddr       string `json:"vault_addr"`        // VAULT_ADDR
+	VaultToken      string `json:"vault_token"`       // VAULT_TOKEN
+	VaultSecretPath string `json:"vault_secret_path"` // VAULT_SECRET_PATH
+}
+
+// ConfigErrors lists every problem with a configuration, rather than only the first.
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return "invalid configuration:\n  " + strings.Join(e, "\n  ")
+}
+
+var tenantNamePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// https://discord.com/developers/docs/resources/webhook#execute-webhook
+var discordWebhookURLPattern = regexp.MustCompile(`^https://((canary|ptb)\.)?discord(app)?\.com/api(/v\d+)?/webhooks/\d+/[\w-]+/?(\?.*)?$`)
+
+// LoadConfig reads a configuration file, in JSON, YAML (.yaml or .yml) or TOML (.toml) by its extension. Unknown
+// keys are rejected, so that typos are not silently ignored.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	ext := strings.ToLower(filepath.Ext(path))
+	switch ext {
+	case "", ".json":
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &value)
+	case ".toml":
+		var table map[string]interface{}
+		_, err = toml.Decode(string(data), &table)
+		value = table
+	default:
+		return nil, fmt.Errorf("%s: must be a .json, .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	// YAML and TOML are converted to JSON, so that every format is decoded (and checked) the same way.
+	if ext != "" && ext != ".json" {
+		data, err = json.Marshal(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	var config Config
+	if err := decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return &config, nil
+}
+
+// getConfigFromEnvironment reads the configuration from environment variables.
+func getConfigFromEnvironment() (*Config, error) {
+	var config Config
+	var errs ConfigErrors
+
+	parseBool := func(name string) *bool {
+		rawValue := os.Getenv(name)
+		if rawValue == "" {
+			return nil
+		}
+		value, err := strconv.ParseBool(rawValue)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: must be a boolean, got %q", name, rawValue))
+			return nil
+		}
+		return &value
+	}
+	parseJSON := func(name string, value interface{}) {
+		if rawValue := os.Getenv(name); rawValue != "" {
+			if err := json.Unmarshal([]byte(rawValue), value); err != nil {
+				errs = append(errs, fmt.Sprintf("%s: must be JSON: %v", name, err))
+			}
+		}
+	}
+
+	config.Discord.WebhookURL = os.Getenv("DISCORD_WEBHOOK_URL")
+	config.Discord.EscalationWebhookURL = os.Getenv("DISCORD_ESCALATION_WEBHOOK_URL")
+	config.Discord.TimestampMarkup = parseBool("DISCORD_TIMESTAMP_MARKUP")
+	config.Discord.DeadlineTimezone = os.Getenv("DEADLINE_TIMEZONE")
+	config.Discord.DeadlineFormat = os.Getenv("DEADLINE_FORMAT")
+	if value := parseBool("RENDER_UNKNOWN_EVENTS"); value != nil {
+		config.Discord.RenderUnknownEvents = *value
+	}
+	if value := parseBool("SUPPRESS_VCS_STATE_CHANGES"); value != nil {
+		config.Discord.SuppressVCSStateChanges = *value
+	}
+	if value := parseBool("SHOW_POSITION_CHANGES"); value != nil {
+		config.Discord.ShowPositionChanges = *value
+	}
+	parseJSON("WORKFLOW_STATE_STYLES", &config.Discord.WorkflowStateStyles)
+	config.Discord.Timeout = os.Getenv("DISCORD_TIMEOUT")
+	if rawValue := os.Getenv("DISCORD_RETRIES"); rawValue != "" {
+		value, err := strconv.Atoi(rawValue)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("DISCORD_RETRIES: must be an integer, got %q", rawValue))
+		} else {
+			config.Discord.Retries = &value
+		}
+	}
+	config.Discord.RetryBackoff = os.Getenv("DISCORD_RETRY_BACKOFF")
+
+	config.Clubhouse.ApiToken = os.Getenv("CLUBHOUSE_API_TOKEN")
+	config.Clubhouse.WebhookSecrets = parseWebhookSecrets(os.Getenv("CLUBHOUSE_WEBHOOK_SECRET"))
+	config.Clubhouse.WebhookStrict = parseBool("CLUBHOUSE_WEBHOOK_STRICT")
+
+	parseJSON("CUSTOM_FIELD_FILTERS", &config.Filters.CustomFields)
+	parseJSON("CUSTOM_FIELD_ROUTES", &config.Routes.CustomFields)
+	parseJSON("SINKS", &config.Sinks)
+
+	config.StoreDir = os.Getenv("STORE_DIR")
+
+	config.Replay.Window = os.Getenv("REPLAY_WINDOW")
+	config.Replay.StoreDir = os.Getenv("REPLAY_STORE_DIR")
+	config.Coalesce.Window = os.Getenv("COALESCE_WINDOW")
+	config.Coalesce.StoreDir = os.Getenv("COALESCE_STORE_DIR")
+
+	parseJSON("DIGEST_CHANNELS", &config.Digests.Channels)
+	config.Digests.StoreDir = os.Getenv("DIGEST_STORE_DIR")
+
+	config.Standup.WebhookURL = os.Getenv("STANDUP_WEBHOOK_URL")
+	config.Standup.Schedule = os.Getenv("STANDUP_SCHEDULE")
+	config.Standup.Timezone = os.Getenv("STANDUP_TIMEZONE")
+
+	config.Templates.Title = os.Getenv("TITLE_TEMPLATE")
+	config.Caches.ReferenceTTL = os.Getenv("REFERENCE_CACHE_TTL")
+
+	config.Secrets = SecretsConfig{
+		Provider:        os.Getenv("SECRETS_PROVIDER"),
+		Dir:             os.Getenv("SECRETS_DIR"),
+		File:            os.Getenv("SECRETS_FILE"),
+		CacheTTL:        os.Getenv("SECRETS_CACHE_TTL"),
+		GCPProject:      os.Getenv("GCP_PROJECT"),
+		VaultAddr:       os.Getenv("VAULT_ADDR"),
+		VaultToken:      os.Getenv("VAULT_TOKEN"),
+		VaultSecretPath: os.Getenv("VAULT_SECRET_PATH"),
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return &config, nil
+}
+
+// loadConfig reads CONFIG_FILE if it is set, or the environment variables otherwise.
+func loadConfig() (*Config, error) {
+	if configFile := os.Getenv("CONFIG_FILE"); configFile != "" {
+		return LoadConfig(configFile)
+	}
+
+	return getConfigFromEnvironment()
+}
+
+// getTenantConfig returns the settings of a tenant, or the top level settings for "".
+func (c Config) getTenantConfig(tenant string) (Config, bool) {
+	if tenant == "" {
+		return c, true
+	}
+
+	tenantConfig, ok := c.Tenants[tenant]
+	if !ok {
+		return Config{}, false
+	}
+	if tenantConfig.Secrets == (SecretsConfig{}) {
+		tenantConfig.Secrets = c.Secrets
+	}
+	if tenantConfig.StoreDir == "" && c.StoreDir != "" {
+		tenantConfig.StoreDir = filepath.Join(c.StoreDir, "tenants", tenant)
+	}
+
+	return tenantConfig, true
+}
+
+// getTenantSecretPrefix returns the prefix of a tenant's secret names, e.g. "ACME_" for "ACME_CLUBHOUSE_API_TOKEN".
+func getTenantSecretPrefix(tenant string) string {
+	if tenant == "" {
+		return ""
+	}
+
+	return strings.ToUpper(strings.ReplaceAll(tenant, "-", "_")) + "_"
+}
+
+// resolveSecrets returns a copy of the configuration with secrets read from its secrets provider. Secrets the
+// provider does not have keep their configured values. A tenant's secret names are prefixed with its name.
+// Sink credentials may also be given as "secret://NAME", which are read by that exact name.
+func (c Config) resolveSecrets(tenant string) (Config, error) {
+	provider, err := getSecretsProvider(c.Secrets)
+	if err != nil {
+		return c, err
+	}
+
+	prefix := getTenantSecretPrefix(tenant)
+
+	c.Discord.WebhookURL, err = getSecret(provider, prefix+"DISCORD_WEBHOOK_URL", c.Discord.WebhookURL)
+	if err != nil {
+		return c, fmt.Errorf("failed to get `%sDISCORD_WEBHOOK_URL`: %v", prefix, err)
+	}
+
+	c.Discord.EscalationWebhookURL, err = getSecret(provider, prefix+"DISCORD_ESCALATION_WEBHOOK_URL", c.Discord.EscalationWebhookURL)
+	if err != nil {
+		return c, fmt.Errorf("failed to get `%sDISCORD_ESCALATION_WEBHOOK_URL`: %v", prefix, err)
+	}
+
+	c.Clubhouse.ApiToken, err = getSecret(provider, prefix+"CLUBHOUSE_API_TOKEN", c.Clubhouse.ApiToken)
+	if err != nil {
+		return c, fmt.Errorf("failed to get `%sCLUBHOUSE_API_TOKEN`: %v", prefix, err)
+	}
+
+	clubhouseWebhookSecret, err := getSecret(provider, prefix+"CLUBHOUSE_WEBHOOK_SECRET", strings.Join(c.Clubhouse.WebhookSecrets, ","))
+	if err != nil {
+		return c, fmt.Errorf("failed to get `%sCLUBHOUSE_WEBHOOK_SECRET`: %v", prefix, err)
+	}
+	c.Clubhouse.WebhookSecrets = parseWebhookSecrets(clubhouseWebhookSecret)
+
+	// The slice is shared with the configuration the secrets are read for, which is read again later.
+	c.Sinks = append([]SinkConfig(nil), c.Sinks...)
+	for i := range c.Sinks {
+		sink := &c.Sinks[i]
+		fields := []struct {
+			name  string
+			value *string
+		}{
+			{"webhook_url", &sink.WebhookURL},
+			{"secret", &sink.Secret},
+			{"smtp_password", &sink.SMTPPassword},
+			{"access_token", &sink.AccessToken},
+		}
+		for _, field := range fields {
+			*field.value, err = resolveSecretReference(provider, *field.value)
+			if err != nil {
+				return c, fmt.Errorf("sinks[%d].%s (SINKS): %v", i, field.name, err)
+			}
+		}
+	}
+
+	if tenant != "" {
+		c.Token, err = getSecret(provider, prefix+"TENANT_TOKEN", c.Token)
+		if err != nil {
+			return c, fmt.Errorf("failed to get `%sTENANT_TOKEN`: %v", prefix, err)
+		}
+	}
+
+	return c, nil
+}
+
+// validateDiscordWebhookURL checks that a URL is a Discord webhook. The URL is not included in the error,
+// as its token is a secret.
+func validateDiscordWebhookURL(webhookURL string) error {
+	parsedURL, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("must be a URL: %v", err)
+	}
+
+	if parsedURL.Host == "" {
+		return fmt.Errorf("must match https://discord.com/api/webhooks/{id}/{token}, got a URL without a host")
+	}
+
+	if !discordWebhookURLPattern.MatchString(webhookURL) {
+		return fmt.Errorf("must match https://discord.com/api/webhooks/{id}/{token}, got a URL to %s", parsedURL.Host)
+	}
+
+	return nil
+}
+
+// newEnvironment validates the configuration (with its secrets resolved), and converts it into the settings used
+// while handling webhooks.
+func (c Config) newEnvironment(tenant string) (environment, error) {
+	// Without a store dir, state is kept in the temporary directory, which is only suitable for a single server.
+	storeDir := c.StoreDir
+	if storeDir == "" {
+		storeDir = filepath.Join(os.TempDir(), "clubhouse-to-discord", tenant)
+	}
+
+	env := environment{
+		Tenant:                      tenant,
+		TenantToken:                 c.Token,
+		DiscordWebhookURL:           c.Discord.WebhookURL,
+		DiscordEscalationWebhookURL: c.Discord.EscalationWebhookURL,
+		DiscordOptions: DiscordOptions{
+			Deadlines: DeadlineFormat{
+				TimestampMarkup: true,
+				Location:        time.UTC,
+				Layout:          defaultDeadlineLayout,
+			},
+			RenderUnknownEvents:     c.Discord.RenderUnknownEvents,
+			SuppressVCSStateChanges: c.Discord.SuppressVCSStateChanges,
+			ShowPositionChanges:     c.Discord.ShowPositionChanges,
+		},
+		ClubhouseApiToken:       c.Clubhouse.ApiToken,
+		ClubhouseWebhookSecrets: c.Clubhouse.WebhookSecrets,
+		ClubhouseWebhookStrict:  len(c.Clubhouse.WebhookSecrets) > 0,
+		ReplayStoreDir:          filepath.Join(storeDir, "replay"),
+		CoalesceStoreDir:        filepath.Join(storeDir, "coalesce"),
+		DigestStoreDir:          filepath.Join(storeDir, "digest"),
+		StandupWebhookURL:       c.Standup.WebhookURL,
+		StandupLocation:         time.UTC,
+		StandupStoreDir:         filepath.Join(storeDir, "standup"),
+	}
+
+	var errs ConfigErrors
+	addError := func(field string, format string, args ...interface{}) {
+		errs = append(errs, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	if tenant == "" {
+		names := make([]string, 0, len(c.Tenants))
+		for name := range c.Tenants {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		tokens := make(map[string]string)
+		for _, name := range names {
+			tenantConfig := c.Tenants[name]
+			if !tenantNamePattern.MatchString(name) {
+				addError("tenants."+name, "names may only contain lower case letters, numbers, \"-\" and \"_\"")
+			}
+			if len(tenantConfig.Tenants) > 0 {
+				addError("tenants."+name+".tenants", "tenants cannot have tenants")
+			}
+			if tenantConfig.Token == "" {
+				continue
+			}
+			if other, ok := tokens[tenantConfig.Token]; ok {
+				addError("tenants."+name+".token", "is the same as the token of tenant %q", other)
+			}
+			tokens[tenantConfig.Token] = name
+		}
+		if c.Token != "" {
+			addError("token", "is only used by tenants")
+		}
+	}
+
+	if env.DiscordWebhookURL == "" {
+		addError("discord.webhook_url (DISCORD_WEBHOOK_URL)", "is required")
+	} else if err := validateDiscordWebhookURL(env.DiscordWebhookURL); err != nil {
+		addError("discord.webhook_url (DISCORD_WEBHOOK_URL)", "%v", err)
+	}
+
+	if env.DiscordEscalationWebhookURL != "" {
+		if err := validateDiscordWebhookURL(env.DiscordEscalationWebhookURL); err != nil {
+			addError("discord.escalation_webhook_url (DISCORD_ESCALATION_WEBHOOK_URL)", "%v", err)
+		}
+	}
+
+	var err error
+	env.DiscordPolicy, err = newDeliveryPolicy(SinkConfig{
+		Timeout:      c.Discord.Timeout,
+		Retries:      c.Discord.Retries,
+		RetryBackoff: c.Discord.RetryBackoff,
+	})
+	if err != nil {
+		addError("discord (DISCORD_TIMEOUT, DISCORD_RETRIES, DISCORD_RETRY_BACKOFF)", "%v", err)
+	}
+
+	if c.Discord.TimestampMarkup != nil {
+		env.DiscordOptions.Deadlines.TimestampMarkup = *c.Discord.TimestampMarkup
+	}
+	if c.Discord.DeadlineTimezone != "" {
+		location, err := time.LoadLocation(c.Discord.DeadlineTimezone)
+		if err != nil {
+			addError("discord.deadline_timezone (DEADLINE_TIMEZONE)", "must be an IANA timezone: %v", err)
+		}
+		env.DiscordOptions.Deadlines.Location = location
+	}
+	if c.Discord.DeadlineFormat != "" {
+		env.DiscordOptions.Deadlines.Layout = c.Discord.DeadlineFormat
+	}
+
+	env.DiscordOptions.WorkflowStateStyles, err = normalizeWorkflowStateStyles(c.Discord.WorkflowStateStyles)
+	if err != nil {
+		addError("discord.workflow_state_styles (WORKFLOW_STATE_STYLES)", "%v", err)
+	}
+
+	if env.ClubhouseApiToken == "" {
+		addError("clubhouse.api_token (CLUBHOUSE_API_TOKEN)", "is required")
+	}
+	if c.Clubhouse.WebhookStrict != nil {
+		env.ClubhouseWebhookStrict = *c.Clubhouse.WebhookStrict
+		if env.ClubhouseWebhookStrict && len(env.ClubhouseWebhookSecrets) == 0 {
+			addError("clubhouse.webhook_strict (CLUBHOUSE_WEBHOOK_STRICT)", "requires a webhook secret (CLUBHOUSE_WEBHOOK_SECRET)")
+		}
+	}
+
+	if c.Templates.Title != "" {
+		env.DiscordOptions.TitleTemplate, err = parseTitleTemplate(c.Templates.Title)
+		if err != nil {
+			addError("templates.title (TITLE_TEMPLATE)", "%v", err)
+		}
+	}
+
+	if c.Caches.ReferenceTTL != "" {
+		env.ReferenceCacheTTL, err = time.ParseDuration(c.Caches.ReferenceTTL)
+		if err != nil || env.ReferenceCacheTTL <= 0 {
+			addError("caches.reference_ttl (REFERENCE_CACHE_TTL)", "must be a positive duration (e.g. \"10m\"), got %q", c.Caches.ReferenceTTL)
+		}
+	}
+
+	env.CustomFieldFilters = c.Filters.CustomFields
+	if err := validateCustomFieldRules(env.CustomFieldFilters); err != nil {
+		addError("filters.custom_fields (CUSTOM_FIELD_FILTERS)", "%v", err)
+	}
+
+	env.CustomFieldRoutes = c.Routes.CustomFields
+	if err := validateCustomFieldRules(env.CustomFieldRoutes); err != nil {
+		addError("routes.custom_fields (CUSTOM_FIELD_ROUTES)", "%v", err)
+	}
+	for i, route := range env.CustomFieldRoutes {
+		if err := validateDiscordWebhookURL(route.WebhookURL); err != nil {
+			addError(fmt.Sprintf("routes.custom_fields[%d].webhook_url (CUSTOM_FIELD_ROUTES)", i), "%v", err)
+		}
+	}
+
+	// Names identify sinks in responses, and the files of batching sinks.
+	sinkIndexesByName := make(map[string]int)
+	for i, sinkConfig := range c.Sinks {
+		sink, err := newSink(sinkConfig, filepath.Join(storeDir, "sinks"))
+		if err != nil {
+			addError(fmt.Sprintf("sinks[%d] (SINKS)", i), "%v", err)
+			continue
+		}
+		if other, ok := sinkIndexesByName[sink.Name()]; ok {
+			addError(fmt.Sprintf("sinks[%d].name (SINKS)", i), "is the same as the name of sinks[%d] (%q), which defaults to the type", other, sink.Name())
+			continue
+		}
+		sinkIndexesByName[sink.Name()] = i
+		policy, err := newDeliveryPolicy(sinkConfig)
+		if err != nil {
+			addError(fmt.Sprintf("sinks[%d] (SINKS)", i), "%v", err)
+			continue
+		}
+		env.Sinks = append(env.Sinks, ConfiguredSink{Sink: sink, Policy: policy})
+	}
+
+	if c.Replay.Window != "" {
+		env.ReplayWindow, err = time.ParseDuration(c.Replay.Window)
+		if err != nil || env.ReplayWindow < 0 {
+			addError("replay.window (REPLAY_WINDOW)", "must be a positive duration (e.g. \"5m\"), got %q", c.Replay.Window)
+		}
+	}
+	if c.Replay.StoreDir != "" {
+		env.ReplayStoreDir = c.Replay.StoreDir
+	}
+
+	if c.Coalesce.Window != "" {
+		env.CoalesceWindow, err = time.ParseDuration(c.Coalesce.Window)
+		if err != nil || env.CoalesceWindow < 0 {
+			addError("coalesce.window (COALESCE_WINDOW)", "must be a positive duration (e.g. \"30s\"), got %q", c.Coalesce.Window)
+		}
+	}
+	if c.Coalesce.StoreDir != "" {
+		env.CoalesceStoreDir = c.Coalesce.StoreDir
+	}
+
+	env.DigestChannels, err = prepareDigestChannels(c.Digests.Channels)
+	if err != nil {
+		addError("digests.channels (DIGEST_CHANNELS)", "%v", err)
+	}
+	for i, channel := range c.Digests.Channels {
+		if err := validateDiscordWebhookURL(channel.WebhookURL); err != nil {
+			addError(fmt.Sprintf("digests.channels[%d].webhook_url (DIGEST_CHANNELS)", i), "%v", err)
+		}
+	}
+	if c.Digests.StoreDir != "" {
+		env.DigestStoreDir = c.Digests.StoreDir
+	}
+
+	if env.StandupWebhookURL == "" {
+		env.StandupWebhookURL = env.DiscordWebhookURL
+	} else if err := validateDiscordWebhookURL(env.StandupWebhookURL); err != nil {
+		addError("standup.webhook_url (STANDUP_WEBHOOK_URL)", "%v", err)
+	}
+	if c.Standup.Schedule != "" {
+		env.StandupSchedule, err = ParseCronSchedule(c.Standup.Schedule)
+		if err != nil {
+			addError("standup.schedule (STANDUP_SCHEDULE)", "%v", err)
+		}
+	}
+	if c.Standup.Timezone != "" {
+		env.StandupLocation, err = time.LoadLocation(c.Standup.Timezone)
+		if err != nil {
+			addError("standup.timezone (STANDUP_TIMEZONE)", "must be an IANA timezone: %v", err)
+		}
+	}
+
+	// Cloud Functions instances each have their own (in-memory) temporary directory, so state kept there would be
+	// lost between instances.
+	if c.StoreDir == "" && isCloudFunction() {
+		var statefulFields []string
+		if env.ReplayWindow > 0 && c.Replay.StoreDir == "" {
+			statefulFields = append(statefulFields, "replay.window")
+		}
+		if env.CoalesceWindow > 0 && c.Coalesce.StoreDir == "" {
+			statefulFields = append(statefulFields, "coalesce.window")
+		}
+		if len(env.DigestChannels) > 0 && c.Digests.StoreDir == "" {
+			statefulFields = append(statefulFields, "digests.channels")
+		}
+		for i, sinkConfig := range c.Sinks {
+			if sinkConfig.Type == "email" && sinkConfig.BatchWindow != "" {
+				statefulFields = append(statefulFields, fmt.Sprintf("sinks[%d].batch_window", i))
+			}
+		}
+		if len(statefulFields) > 0 {
+			addError("store_dir (STORE_DIR)", "is required on Cloud Functions for %s (a shared volume, not the temporary directory)", strings.Join(statefulFields, ", "))
+		}
+	}
+
+	if len(errs) > 0 {
+		return environment{}, errs
+	}
+
+	return env, nil
+}
+
+// isCloudFunction reports whether this is running on Cloud Functions, which sets FUNCTION_TARGET to the entry point.
+func isCloudFunction() bool {
+	return os.Getenv("FUNCTION_TARGET") != ""
+}
+
+// ValidateConfig checks a configuration and its tenants, including that their secrets can be read.
+func ValidateConfig(config *Config) error {
+	var errs ConfigErrors
+
+	tenants := []string{""}
+	for tenant := range config.Tenants {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+
+	for _, tenant := range tenants {
+		fieldPrefix := ""
+		if tenant != "" {
+			fieldPrefix = "tenants." + tenant + "."
+		}
+
+		tenantConfig, _ := config.getTenantConfig(tenant)
+		resolvedConfig, err := tenantConfig.resolveSecrets(tenant)
+		if err == nil {
+			_, err = resolvedConfig.newEnvironment(tenant)
+		}
+
+		if tenantErrs, ok := err.(ConfigErrors); ok {
+			for _, tenantErr := range tenantErrs {
+				errs = append(errs, fieldPrefix+tenantErr)
+			}
+		} else if err != nil {
+			errs = append(errs, fieldPrefix+err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// ValidateCurrentConfig checks the configuration the function would use (CONFIG_FILE, or the environment variables).
+func ValidateCurrentConfig() error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	return ValidateConfig(config)
+}
@@ -0,0 +1,375 @@
+package function
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestConfig() Config {
+	return Config{
+		Discord:   DiscordConfig{WebhookURL: "https://discord.com/api/webhooks/1/token"},
+		Clubhouse: ClubhouseConfig{ApiToken: "token"},
+	}
+}
+
+func TestStoreDirIsRequiredOnCloudFunctions(t *testing.T) {
+	defer os.Unsetenv("FUNCTION_TARGET")
+
+	config := newTestConfig()
+	config.Coalesce.Window = "30s"
+
+	if _, err := config.newEnvironment(""); err != nil {
+		t.Errorf("newEnvironment() = %v, want no error outside of Cloud Functions", err)
+	}
+
+	os.Setenv("FUNCTION_TARGET", "F")
+	if _, err := config.newEnvironment(""); err == nil || !strings.Contains(err.Error(), "store_dir (STORE_DIR)") {
+		t.Errorf("newEnvironment() = %v, want store_dir to be required", err)
+	}
+
+	config.StoreDir = "/mnt/state"
+	env, err := config.newEnvironment("")
+	if err != nil {
+		t.Fatalf("newEnvironment() = %v", err)
+	}
+	if env.CoalesceStoreDir != "/mnt/state/coalesce" {
+		t.Errorf("CoalesceStoreDir = %q, want /mnt/state/coalesce", env.CoalesceStoreDir)
+	}
+
+	config.Tenants = map[string]Config{"acme": newTestConfig()}
+	tenantConfig, _ := config.getTenantConfig("acme")
+	if tenantConfig.StoreDir != "/mnt/state/tenants/acme" {
+		t.Errorf("tenant StoreDir = %q, want /mnt/state/tenants/acme", tenantConfig.StoreDir)
+	}
+}
+
+// useTestConfig makes the handlers use a configuration, until the returned function is called.
+func useTestConfig(config *Config) func() {
+	environmentCache.Lock()
+	defer environmentCache.Unlock()
+
+	environmentCache.config = config
+	environmentCache.envsByTenant = make(map[string]cachedEnvironment)
+
+	return func() {
+		environmentCache.Lock()
+		defer environmentCache.Unlock()
+
+		environmentCache.config = nil
+		environmentCache.envsByTenant = make(map[string]cachedEnvironment)
+	}
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"config.json": `{
+  "discord": {"webhook_url": "https://discord.com/api/webhooks/1/token", "timestamp_markup": false},
+  "clubhouse": {"api_token": "token", "webhook_secrets": ["new", "old"]},
+  "sinks": [{"name": "slack", "type": "slack", "webhook_url": "https://hooks.slack.com/services/T000/B000/XXXX"}],
+  "templates": {"title": "{{.Name}}"},
+  "caches": {"reference_ttl": "1h"}
+}`,
+		"config.yaml": `discord:
+  webhook_url: https://discord.com/api/webhooks/1/token
+  timestamp_markup: false
+clubhouse:
+  api_token: token
+  webhook_secrets:
+    - new
+    - old
+sinks:
+  - name: slack
+    type: slack
+    webhook_url: https://hooks.slack.com/services/T000/B000/XXXX
+templates:
+  title: "{{.Name}}"
+caches:
+  reference_ttl: 1h
+`,
+		"config.toml": `[discord]
+webhook_url = "https://discord.com/api/webhooks/1/token"
+timestamp_markup = false
+
+[clubhouse]
+api_token = "token"
+webhook_secrets = ["new", "old"]
+
+[[sinks]]
+name = "slack"
+type = "slack"
+webhook_url = "https://hooks.slack.com/services/T000/B000/XXXX"
+
+[templates]
+title = "{{.Name}}"
+
+[caches]
+reference_ttl = "1h"
+`,
+	}
+
+	var want *Config
+	for _, name := range []string{"config.json", "config.yaml", "config.toml"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(files[name]), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := LoadConfig(path)
+		if err != nil {
+			t.Fatalf("LoadConfig(%s) = %v", name, err)
+		}
+		if want == nil {
+			want = config
+		} else if !reflect.DeepEqual(config, want) {
+			t.Errorf("LoadConfig(%s) = %+v, want %+v", name, config, want)
+		}
+	}
+	if want.Templates.Title != "{{.Name}}" || want.Caches.ReferenceTTL != "1h" || len(want.Sinks) != 1 {
+		t.Errorf("getConfigFromEnvironment() = %+v", want)
+	}
+
+	// Unknown keys are rejected in every format.
+	for name, data := range map[string]string{
+		"typo.yaml": "discord:\n  webhook_ulr: x\n",
+		"typo.toml": "[discord]\nwebhook_ulr = \"x\"\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadConfig(path); err == nil || !strings.Contains(err.Error(), "webhook_ulr") {
+			t.Errorf("LoadConfig(%s) = %v, want an unknown field error", name, err)
+		}
+	}
+
+	if _, err := LoadConfig(filepath.Join(dir, "config.ini")); err == nil {
+		t.Error("LoadConfig(config.ini) = nil, want an error")
+	}
+}
+
+func TestLoadConfigValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name  string
+		data  string
+		check func(config *Config) bool
+		err   string
+	}{
+		{"hex.yaml", "discord:\n  retries: 0x1f\n", func(config *Config) bool { return *config.Discord.Retries == 31 }, ""},
+		{"true.yaml", "discord:\n  timestamp_markup: true\n", func(config *Config) bool { return *config.Discord.TimestampMarkup }, ""},
+		{"anchor.yaml", "clubhouse:\n  api_token: &token token\ntenants:\n  acme:\n    clubhouse:\n      api_token: *token\n", func(config *Config) bool {
+			return config.Tenants["acme"].Clubhouse.ApiToken == "token"
+		}, ""},
+		// An empty item is null, which leaves the secret empty.
+		{"empty item.yaml", "clubhouse:\n  webhook_secrets:\n    - \n", func(config *Config) bool {
+			return len(config.Clubhouse.WebhookSecrets) == 1 && config.Clubhouse.WebhookSecrets[0] == ""
+		}, ""},
+		// YAML 1.2 only has true and false.
+		{"yes.yaml", "discord:\n  timestamp_markup: yes\n", nil, "cannot unmarshal string"},
+		{"on.yaml", "discord:\n  timestamp_markup: on\n", nil, "cannot unmarshal string"},
+		{"inf.yaml", "discord:\n  retries: .inf\n", nil, "unsupported value: +Inf"},
+		{"inf.toml", "[discord]\nretries = inf\n", nil, "inf"},
+		{"nan.toml", "[discord]\nretries = nan\n", nil, "nan"},
+		{"int64.toml", "[discord]\nretries = 2\n", func(config *Config) bool { return *config.Discord.Retries == 2 }, ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(dir, test.name)
+			if err := ioutil.WriteFile(path, []byte(test.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			config, err := LoadConfig(path)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Errorf("LoadConfig() = %v, want an error containing %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfig() = %v", err)
+			}
+			if !test.check(config) {
+				t.Errorf("LoadConfig() = %+v", config)
+			}
+		})
+	}
+}
+
+func TestTitleTemplate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"profile": {"name": "jane doe"}}`))
+	}))
+	defer server.Close()
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: "token", BaseURL: server.URL}
+
+	config := newTestConfig()
+	config.Templates.Title = "{{.Nmae}}"
+	if _, err := config.newEnvironment(""); err == nil || !strings.Contains(err.Error(), "templates.title (TITLE_TEMPLATE)") {
+		t.Errorf("newEnvironment() = %v, want an error for the unknown field", err)
+	}
+
+	config.Templates.Title = "{{.Name}}: {{.Verb}}{{if .Actor}} by {{.Actor}}{{end}}"
+	env, err := config.newEnvironment("")
+	if err != nil {
+		t.Fatalf("newEnvironment() = %v", err)
+	}
+
+	tests := []struct {
+		memberID string
+		want     string
+	}{
+		{"", "Fix the login page: updated"},
+		{"member", "Fix the login page: updated by Jane Doe"},
+	}
+	for _, test := range tests {
+		title, err := getWebhookTitle(clubhouseApiClient, env.DiscordOptions, test.memberID, "updated", "story", "Fix the login page")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if title != test.want {
+			t.Errorf("getWebhookTitle() = %q, want %q", title, test.want)
+		}
+	}
+
+	// Without a template, titles are unchanged.
+	title, err := getWebhookTitle(clubhouseApiClient, DiscordOptions{}, "member", "updated", "story", "Fix the login page")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if title != "Jane Doe updated story: Fix the login page" {
+		t.Errorf("getWebhookTitle() = %q", title)
+	}
+}
+
+func TestReferenceCacheTTL(t *testing.T) {
+	config := newTestConfig()
+	config.Caches.ReferenceTTL = "-1m"
+	if _, err := config.newEnvironment(""); err == nil || !strings.Contains(err.Error(), "caches.reference_ttl (REFERENCE_CACHE_TTL)") {
+		t.Errorf("newEnvironment() = %v, want an error for the negative duration", err)
+	}
+
+	config.Caches.ReferenceTTL = "1h"
+	env, err := config.newEnvironment("")
+	if err != nil {
+		t.Fatalf("newEnvironment() = %v", err)
+	}
+	if ttl := env.newClubhouseApiClient().getCacheTTL(); ttl != time.Hour {
+		t.Errorf("getCacheTTL() = %v, want 1h", ttl)
+	}
+	if ttl := (&ClubhouseApiClient{}).getCacheTTL(); ttl != defaultReferenceCacheTTL {
+		t.Errorf("getCacheTTL() = %v, want the default", ttl)
+	}
+
+	// References cached longer ago than the TTL are fetched again.
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: t.Name(), CacheTTL: time.Minute}
+	setCachedReferences(clubhouseApiClient, []ClubhouseReference{{EntityType: "project", ID: 1, Name: "Mobile"}})
+	if _, ok := getCachedReference(clubhouseApiClient, "project:1"); !ok {
+		t.Error("getCachedReference() = false, want the cached project")
+	}
+
+	referenceCache.Lock()
+	key := getReferenceCacheKey(clubhouseApiClient, "project:1")
+	cached := referenceCache.referencesByKey[key]
+	cached.CachedAt = time.Now().Add(-2 * time.Minute)
+	referenceCache.referencesByKey[key] = cached
+	referenceCache.Unlock()
+
+	if _, ok := getCachedReference(clubhouseApiClient, "project:1"); ok {
+		t.Error("getCachedReference() = true, want the expired project to be fetched again")
+	}
+}
+
+func TestDiscordDeliveryPolicy(t *testing.T) {
+	env, err := newTestConfig().newEnvironment("")
+	if err != nil {
+		t.Fatalf("newEnvironment() = %v", err)
+	}
+	if env.DiscordPolicy != defaultDeliveryPolicy {
+		t.Errorf("DiscordPolicy = %+v, want the default", env.DiscordPolicy)
+	}
+
+	defer os.Unsetenv("DISCORD_WEBHOOK_URL")
+	defer os.Unsetenv("CLUBHOUSE_API_TOKEN")
+	defer os.Unsetenv("DISCORD_TIMEOUT")
+	defer os.Unsetenv("DISCORD_RETRIES")
+	defer os.Unsetenv("DISCORD_RETRY_BACKOFF")
+	os.Setenv("DISCORD_WEBHOOK_URL", "https://discord.com/api/webhooks/1/token")
+	os.Setenv("CLUBHOUSE_API_TOKEN", "token")
+	os.Setenv("DISCORD_TIMEOUT", "30s")
+	os.Setenv("DISCORD_RETRIES", "0")
+	os.Setenv("DISCORD_RETRY_BACKOFF", "5s")
+
+	config, err := getConfigFromEnvironment()
+	if err != nil {
+		t.Fatalf("getConfigFromEnvironment() = %v", err)
+	}
+	env, err = config.newEnvironment("")
+	if err != nil {
+		t.Fatalf("newEnvironment() = %v", err)
+	}
+	want := DeliveryPolicy{Timeout: 30 * time.Second, Retries: 0, RetryBackoff: 5 * time.Second}
+	if env.DiscordPolicy != want {
+		t.Errorf("DiscordPolicy = %+v, want %+v", env.DiscordPolicy, want)
+	}
+
+	os.Setenv("DISCORD_RETRIES", "some")
+	if _, err := getConfigFromEnvironment(); err == nil || !strings.Contains(err.Error(), "DISCORD_RETRIES") {
+		t.Errorf("getConfigFromEnvironment() = %v, want an error for the retries", err)
+	}
+
+	invalidConfig := newTestConfig()
+	invalidConfig.Discord.Timeout = "-1s"
+	if _, err := invalidConfig.newEnvironment(""); err == nil || !strings.Contains(err.Error(), "DISCORD_TIMEOUT") {
+		t.Errorf("newEnvironment() = %v, want an error for the timeout", err)
+	}
+}
+
+func TestLoadEnvironments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Unsetenv("CONFIG_FILE")
+	defer useTestConfig(nil)()
+
+	path := filepath.Join(dir, "config.yaml")
+	os.Setenv("CONFIG_FILE", path)
+
+	if err := ioutil.WriteFile(path, []byte("discord:\n  webhook_url: https://example.com\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadEnvironments(); err == nil || !strings.Contains(err.Error(), "clubhouse.api_token (CLUBHOUSE_API_TOKEN): is required") {
+		t.Errorf("LoadEnvironments() = %v, want every problem to be reported", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("discord:\n  webhook_url: https://discord.com/api/webhooks/1/token\nclubhouse:\n  api_token: token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadEnvironments(); err != nil {
+		t.Fatalf("LoadEnvironments() = %v", err)
+	}
+	if env, err := getEnvironment(); err != nil || env.ClubhouseApiToken != "token" {
+		t.Errorf("getEnvironment() = %+v, %v", env, err)
+	}
+}
@@ -0,0 +1,135 @@
+package function
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A CronSchedule is a standard 5 field cron expression (minute, hour, day of month, month, day of week).
+type CronSchedule struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+
+	// When both day fields are restricted, either may match (as in cron).
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+func ParseCronSchedule(expression string) (*CronSchedule, error) {
+	parts := strings.Fields(expression)
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields: %q", expression)
+	}
+
+	var schedule CronSchedule
+	var err error
+
+	if schedule.minutes, err = parseCronField(parts[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %v", err)
+	}
+	if schedule.hours, err = parseCronField(parts[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %v", err)
+	}
+	if schedule.daysOfMonth, err = parseCronField(parts[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month field: %v", err)
+	}
+	if schedule.months, err = parseCronField(parts[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month field: %v", err)
+	}
+	if schedule.daysOfWeek, err = parseCronField(parts[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week field: %v", err)
+	}
+
+	// Both 0 and 7 are Sunday.
+	if schedule.daysOfWeek[7] {
+		schedule.daysOfWeek[0] = true
+	}
+
+	schedule.anyDayOfMonth = strings.HasPrefix(parts[2], "*")
+	schedule.anyDayOfWeek = strings.HasPrefix(parts[4], "*")
+
+	return &schedule, nil
+}
+
+// parseCronField parses a field made of comma separated values, ranges ("1-5"), wildcards ("*") and steps ("*/15").
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step: %q", part)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+
+			var err error
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value: %q", part)
+			}
+			end = start
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, fmt.Errorf("invalid value: %q", part)
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("value out of range %d-%d: %q", min, max, part)
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func (s *CronSchedule) Matches(t time.Time) bool {
+	if !s.minutes[t.Minute()] || !s.hours[t.Hour()] || !s.months[int(t.Month())] {
+		return false
+	}
+
+	dayOfMonth := s.daysOfMonth[t.Day()]
+	dayOfWeek := s.daysOfWeek[int(t.Weekday())]
+
+	if !s.anyDayOfMonth && !s.anyDayOfWeek {
+		return dayOfMonth || dayOfWeek
+	}
+
+	return dayOfMonth && dayOfWeek
+}
+
+// MatchesBetween reports whether the schedule matches any minute after `after`, up to and including `until`.
+func (s *CronSchedule) MatchesBetween(after time.Time, until time.Time) bool {
+	// Give up on gaps of more than a year, rather than checking every minute.
+	if earliest := until.AddDate(-1, 0, 0); after.Before(earliest) {
+		after = earliest
+	}
+
+	for t := after.Truncate(time.Minute).Add(time.Minute); !t.After(until); t = t.Add(time.Minute) {
+		if s.Matches(t) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+package function
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		expression string
+		valid      bool
+	}{
+		{"0 9 * * 1-5", true},
+		{"*/15 * * * *", true},
+		{"0 9,17 1 * 0", true},
+		{"30 8 * * 7", true},
+		{"5/10 * * * *", true},
+		{"0 9 * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"a * * * *", false},
+	}
+
+	for _, test := range tests {
+		_, err := ParseCronSchedule(test.expression)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("ParseCronSchedule(%q) = %v, want valid = %t", test.expression, err, test.valid)
+		}
+	}
+}
+
+func TestCronScheduleMatches(t *testing.T) {
+	tests := []struct {
+		expression string
+		time       string
+		matches    bool
+	}{
+		{"0 9 * * 1-5", "2021-03-01T09:00:00Z", true},  // Monday
+		{"0 9 * * 1-5", "2021-03-06T09:00:00Z", false}, // Saturday
+		{"0 9 * * 1-5", "2021-03-01T09:01:00Z", false},
+		{"*/15 * * * *", "2021-03-01T10:45:00Z", true},
+		{"*/15 * * * *", "2021-03-01T10:50:00Z", false},
+		{"5/10 * * * *", "2021-03-01T10:25:00Z", true},
+		{"0 0 * * 7", "2021-03-07T00:00:00Z", true}, // Sunday
+		{"0 0 * * 0", "2021-03-07T00:00:00Z", true},
+		// Either day field may match when both are restricted.
+		{"0 0 1 * 1", "2021-03-01T00:00:00Z", true},
+		{"0 0 1 * 1", "2021-03-08T00:00:00Z", true},
+		{"0 0 1 * 1", "2021-03-09T00:00:00Z", false},
+		// Both must match when one is a wildcard.
+		{"0 0 1 * *", "2021-03-02T00:00:00Z", false},
+	}
+
+	for _, test := range tests {
+		schedule, err := ParseCronSchedule(test.expression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		at, err := time.Parse(time.RFC3339, test.time)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if matches := schedule.Matches(at); matches != test.matches {
+			t.Errorf("ParseCronSchedule(%q).Matches(%s) = %t, want %t", test.expression, test.time, matches, test.matches)
+		}
+	}
+}
+
+func TestCronScheduleMatchesBetween(t *testing.T) {
+	schedule, err := ParseCronSchedule("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nine := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		after   time.Time
+		until   time.Time
+		matches bool
+	}{
+		{nine.Add(-time.Hour), nine, true},
+		{nine.Add(-time.Hour), nine.Add(-time.Minute), false},
+		// The minute that was last posted at is not matched again.
+		{nine, nine.Add(time.Hour), false},
+		{nine.Add(30 * time.Second), nine.Add(time.Hour), false},
+		// Missed schedules are caught up on.
+		{nine.Add(-72 * time.Hour), nine.Add(-71 * time.Hour), false},
+		{nine.Add(-72 * time.Hour), nine.Add(-time.Hour), true},
+	}
+
+	for _, test := range tests {
+		if matches := schedule.MatchesBetween(test.after, test.until); matches != test.matches {
+			t.Errorf("MatchesBetween(%s, %s) = %t, want %t", test.after, test.until, matches, test.matches)
+		}
+	}
+}
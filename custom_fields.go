@@ -0,0 +1,193 @@
+package function
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A custom field value (e.g. Severity=Critical) to filter or route events by.
+type CustomFieldRule struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+	// Only used for routes.
+	WebhookURL string `json:"webhook_url,omitempty"`
+}
+
+// A resolved custom field value, e.g. "Priority" and "High".
+type namedCustomFieldValue struct {
+	Field string
+	Value string
+}
+
+func validateCustomFieldRules(rules []CustomFieldRule) error {
+	for _, rule := range rules {
+		if rule.Field == "" || rule.Value == "" {
+			return fmt.Errorf("custom field rule is missing a field or value: %+v", rule)
+		}
+	}
+
+	return nil
+}
+
+// customFieldCache holds the workspace's custom fields, which are needed for every webhook that has any.
+var customFieldCache = newWorkspaceCache()
+
+func getCustomFieldsByID(clubhouseApiClient *ClubhouseApiClient) (map[string]GetCustomFieldResponse, error) {
+	customFieldsByID, err := customFieldCache.get(clubhouseApiClient, func() (interface{}, error) {
+		customFields, err := clubhouseApiClient.ListCustomFields()
+		if err != nil {
+			return nil, err
+		}
+
+		customFieldsByID := make(map[string]GetCustomFieldResponse, len(customFields))
+		for _, customField := range customFields {
+			customFieldsByID[customField.ID] = customField
+		}
+
+		return customFieldsByID, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return customFieldsByID.(map[string]GetCustomFieldResponse), nil
+}
+
+func resolveCustomFieldValues(
+	customFieldsByID map[string]GetCustomFieldResponse,
+	values []ClubhouseCustomFieldValue,
+) []namedCustomFieldValue {
+	namedValues := make([]namedCustomFieldValue, len(values))
+
+	for i, value := range values {
+		namedValues[i] = namedCustomFieldValue{Field: "Custom Field", Value: value.Value}
+
+		customField, ok := customFieldsByID[value.FieldID]
+		if !ok {
+			continue
+		}
+		namedValues[i].Field = customField.Name
+
+		for _, enumValue := range customField.Values {
+			if enumValue.ID == value.ValueID {
+				namedValues[i].Value = enumValue.Value
+			}
+		}
+	}
+
+	return namedValues
+}
+
+func getCustomFieldActionFields(clubhouseApiClient *ClubhouseApiClient, values []ClubhouseCustomFieldValue) ([]EventField, error) {
+	customFieldsByID, err := getCustomFieldsByID(clubhouseApiClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []EventField
+	for _, value := range resolveCustomFieldValues(customFieldsByID, values) {
+		fields = append(fields, EventField{
+			Name:   value.Field,
+			Value:  plainText(value.Value),
+			Inline: true,
+		})
+	}
+
+	return fields, nil
+}
+
+// getCustomFieldChangesFields pairs removed and added values of the same custom field, e.g. "Low -> High".
+func getCustomFieldChangesFields(
+	clubhouseApiClient *ClubhouseApiClient,
+	adds []ClubhouseCustomFieldValue,
+	removes []ClubhouseCustomFieldValue,
+) ([]EventField, error) {
+	customFieldsByID, err := getCustomFieldsByID(clubhouseApiClient)
+	if err != nil {
+		return nil, err
+	}
+
+	var fieldIDs []string
+	seenFieldIDs := make(map[string]bool)
+	oldValues := make(map[string]string)
+	newValues := make(map[string]string)
+
+	for _, value := range removes {
+		if !seenFieldIDs[value.FieldID] {
+			seenFieldIDs[value.FieldID] = true
+			fieldIDs = append(fieldIDs, value.FieldID)
+		}
+		oldValues[value.FieldID] = resolveCustomFieldValues(customFieldsByID, []ClubhouseCustomFieldValue{value})[0].Value
+	}
+	for _, value := range adds {
+		if !seenFieldIDs[value.FieldID] {
+			seenFieldIDs[value.FieldID] = true
+			fieldIDs = append(fieldIDs, value.FieldID)
+		}
+		newValues[value.FieldID] = resolveCustomFieldValues(customFieldsByID, []ClubhouseCustomFieldValue{value})[0].Value
+	}
+
+	var fields []EventField
+	for _, fieldID := range fieldIDs {
+		name := "Custom Field"
+		if customField, ok := customFieldsByID[fieldID]; ok {
+			name = customField.Name
+		}
+
+		oldValue, ok := oldValues[fieldID]
+		if !ok {
+			oldValue = "None"
+		}
+		newValue, ok := newValues[fieldID]
+		if !ok {
+			newValue = "None"
+		}
+
+		fields = append(fields, EventField{
+			Name:  name,
+			Value: plainText(fmt.Sprintf("%s -> %s", oldValue, newValue)),
+		})
+	}
+
+	return fields, nil
+}
+
+// getWebhookCustomFieldValues returns the current custom field values of the story a webhook is about.
+func getWebhookCustomFieldValues(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook) ([]namedCustomFieldValue, error) {
+	storyAction, ok := findAction(webhook, "story")
+	if !ok {
+		return nil, nil
+	}
+
+	values := storyAction.CustomFields
+	if len(values) == 0 && storyAction.Action == "update" {
+		story, err := clubhouseApiClient.GetStory(storyAction.ID)
+		if err != nil {
+			return nil, err
+		}
+		values = story.CustomFields
+	}
+
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	customFieldsByID, err := getCustomFieldsByID(clubhouseApiClient)
+	if err != nil {
+		return nil, err
+	}
+
+	return resolveCustomFieldValues(customFieldsByID, values), nil
+}
+
+func matchCustomFieldRules(values []namedCustomFieldValue, rules []CustomFieldRule) (CustomFieldRule, bool) {
+	for _, rule := range rules {
+		for _, value := range values {
+			if strings.EqualFold(rule.Field, value.Field) && strings.EqualFold(rule.Value, value.Value) {
+				return rule, true
+			}
+		}
+	}
+
+	return CustomFieldRule{}, false
+}
@@ -0,0 +1,40 @@
+package function
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetCustomFieldsByIDIsCached(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = w.Write([]byte(`[{"id": "f1", "name": "Severity", "values": [{"id": "v1", "value": "Critical"}]}]`))
+	}))
+	defer server.Close()
+
+	// The cache outlives the test, so start from an empty one for -count to repeat it.
+	cache := customFieldCache
+	customFieldCache = newWorkspaceCache()
+	defer func() { customFieldCache = cache }()
+
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: t.Name(), BaseURL: server.URL}
+	values := []ClubhouseCustomFieldValue{{FieldID: "f1", ValueID: "v1"}}
+
+	for i := 0; i < 3; i++ {
+		customFieldsByID, err := getCustomFieldsByID(clubhouseApiClient)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		namedValues := resolveCustomFieldValues(customFieldsByID, values)
+		if namedValues[0].Field != "Severity" || namedValues[0].Value != "Critical" {
+			t.Errorf("resolveCustomFieldValues() = %+v", namedValues)
+		}
+	}
+
+	if requests != 1 {
+		t.Errorf("custom fields were requested %d times, want 1", requests)
+	}
+}
@@ -0,0 +1,42 @@
+package function
+
+import (
+	"time"
+)
+
+const defaultDeadlineLayout = "Mon, Jan 2 2006"
+
+type DeadlineFormat struct {
+	// Render deadlines as timestamps, so that each viewer sees their local time on sinks that support it.
+	TimestampMarkup bool
+	// The timezone and layout used when timestamp markup is not available.
+	Location *time.Location
+	Layout   string
+}
+
+func (f DeadlineFormat) Format(deadline *time.Time) Text {
+	if deadline == nil {
+		return plainText("No Date")
+	}
+
+	if f.TimestampMarkup {
+		return concatText(
+			timeText(TextSpanKind_Date, *deadline),
+			plainText(" ("),
+			timeText(TextSpanKind_RelativeDate, *deadline),
+			plainText(")"),
+		)
+	}
+
+	location := f.Location
+	if location == nil {
+		location = time.UTC
+	}
+
+	layout := f.Layout
+	if layout == "" {
+		layout = defaultDeadlineLayout
+	}
+
+	return plainText(deadline.In(location).Format(layout))
+}
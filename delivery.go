@@ -0,0 +1,183 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var defaultDeliveryPolicy = DeliveryPolicy{
+	Timeout:      10 * time.Second,
+	Retries:      2,
+	RetryBackoff: time.Second,
+}
+
+// A DeliveryPolicy is how long a sink is given to send an event, and how often it is retried.
+type DeliveryPolicy struct {
+	// For each attempt.
+	Timeout time.Duration
+	Retries int
+	// Doubled for each retry after the first.
+	RetryBackoff time.Duration
+}
+
+// A ConfiguredSink is a sink with its delivery policy.
+type ConfiguredSink struct {
+	Sink   Sink
+	Policy DeliveryPolicy
+}
+
+// SinkStatusError is returned by sinks for responses with a non-2xx status code.
+type SinkStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *SinkStatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected status code %d", e.StatusCode)
+	}
+
+	return fmt.Sprintf("unexpected status code %d: %q", e.StatusCode, e.Body)
+}
+
+// PermanentSinkError is returned by sinks for failures that retrying cannot fix.
+type PermanentSinkError struct {
+	Err error
+}
+
+func (e *PermanentSinkError) Error() string {
+	return e.Err.Error()
+}
+
+// SinkResult is the outcome of sending an event to a sink, as summarised in the response to Clubhouse.
+type SinkResult struct {
+	Sink     string `json:"sink"`
+	Sent     bool   `json:"sent"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error,omitempty"`
+}
+
+func newDeliveryPolicy(config SinkConfig) (DeliveryPolicy, error) {
+	policy := defaultDeliveryPolicy
+
+	if config.Timeout != "" {
+		timeout, err := time.ParseDuration(config.Timeout)
+		if err != nil || timeout <= 0 {
+			return policy, fmt.Errorf("timeout: must be a positive duration (e.g. \"10s\"), got %q", config.Timeout)
+		}
+		policy.Timeout = timeout
+	}
+
+	if config.Retries != nil {
+		if *config.Retries < 0 {
+			return policy, fmt.Errorf("retries: must not be negative, got %d", *config.Retries)
+		}
+		policy.Retries = *config.Retries
+	}
+
+	if config.RetryBackoff != "" {
+		retryBackoff, err := time.ParseDuration(config.RetryBackoff)
+		if err != nil || retryBackoff < 0 {
+			return policy, fmt.Errorf("retry_backoff: must be a positive duration (e.g. \"1s\"), got %q", config.RetryBackoff)
+		}
+		policy.RetryBackoff = retryBackoff
+	}
+
+	return policy, nil
+}
+
+// deliverToSinks sends an event to every sink at once, so that a slow or failing sink does not hold up the others.
+func deliverToSinks(sinks []ConfiguredSink, event Event) []SinkResult {
+	results := make([]SinkResult, len(sinks))
+
+	var wg sync.WaitGroup
+	for i, sink := range sinks {
+		wg.Add(1)
+		go func(i int, sink ConfiguredSink) {
+			defer wg.Done()
+			results[i] = deliverToSink(sink, event)
+		}(i, sink)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// deliverToSink sends an event to a sink, retrying failures that may be temporary.
+func deliverToSink(sink ConfiguredSink, event Event) SinkResult {
+	result := SinkResult{Sink: sink.Sink.Name()}
+	backoff := sink.Policy.RetryBackoff
+
+	for {
+		result.Attempts++
+
+		err := sendWithTimeout(sink, event)
+		if err == nil {
+			result.Sent = true
+			result.Error = ""
+			return result
+		}
+
+		log.Printf("\nfailed to send to sink %q (attempt %d): %v \n", result.Sink, result.Attempts, err)
+		result.Error = err.Error()
+
+		if result.Attempts > sink.Policy.Retries || !isTemporarySinkError(err) {
+			return result
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+func sendWithTimeout(sink ConfiguredSink, event Event) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), sink.Policy.Timeout)
+	defer cancel()
+
+	// A sink that panics fails on its own, instead of taking down the other sinks.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	return sink.Sink.Send(ctx, event)
+}
+
+// isTemporarySinkError reports whether a failure is worth retrying: server errors, rate limits, and network
+// errors (including timeouts). Other responses, such as an invalid webhook, will fail again.
+func isTemporarySinkError(err error) bool {
+	switch err := err.(type) {
+	case *PermanentSinkError:
+		return false
+	case *SinkStatusError:
+		return err.StatusCode >= 500 || err.StatusCode == http.StatusTooManyRequests
+	default:
+		return true
+	}
+}
+
+// getDeliveryStatusCode returns the status code to respond to Clubhouse with: 200 if every sink was sent to,
+// 207 if some were, and 502 if none were. Partial failures are not server errors, so that Clubhouse does not
+// resend the webhook to the sinks that did receive it.
+func getDeliveryStatusCode(results []SinkResult) int {
+	sent := 0
+	for _, result := range results {
+		if result.Sent {
+			sent++
+		}
+	}
+
+	switch {
+	case sent == len(results):
+		return http.StatusOK
+	case sent > 0:
+		return http.StatusMultiStatus
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,225 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSink fails with each of errs in turn, then succeeds. A nil error succeeds, errBlock waits for the attempt
+// to time out, and errPanic panics.
+type fakeSink struct {
+	sync.Mutex
+	name     string
+	errs     []error
+	attempts int
+}
+
+var (
+	errBlock = errors.New("block")
+	errPanic = errors.New("panic")
+)
+
+func (s *fakeSink) Name() string {
+	return s.name
+}
+
+func (s *fakeSink) Send(ctx context.Context, event Event) error {
+	s.Lock()
+	attempt := s.attempts
+	s.attempts++
+	s.Unlock()
+
+	if attempt >= len(s.errs) {
+		return nil
+	}
+
+	switch err := s.errs[attempt]; err {
+	case errBlock:
+		<-ctx.Done()
+		return ctx.Err()
+	case errPanic:
+		panic("sink bug")
+	default:
+		return err
+	}
+}
+
+func TestDeliverToSink(t *testing.T) {
+	policy := DeliveryPolicy{Timeout: 20 * time.Millisecond, Retries: 2, RetryBackoff: time.Millisecond}
+	errNetwork := errors.New("connection refused")
+
+	tests := []struct {
+		name     string
+		errs     []error
+		sent     bool
+		attempts int
+		err      string
+	}{
+		{"sent", nil, true, 1, ""},
+		{"network error", []error{errNetwork}, true, 2, ""},
+		{"server errors", []error{&SinkStatusError{StatusCode: 502}, &SinkStatusError{StatusCode: 503}}, true, 3, ""},
+		{"rate limited", []error{&SinkStatusError{StatusCode: http.StatusTooManyRequests}}, true, 2, ""},
+		{"timeout", []error{errBlock}, true, 2, ""},
+		{"panic", []error{errPanic}, true, 2, ""},
+		{"out of retries", []error{errNetwork, errNetwork, errNetwork}, false, 3, "connection refused"},
+		{"client error", []error{&SinkStatusError{StatusCode: http.StatusBadRequest, Body: "invalid"}}, false, 1, `unexpected status code 400: "invalid"`},
+		{"permanent", []error{&PermanentSinkError{Err: errors.New("invalid event")}}, false, 1, "invalid event"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink := &fakeSink{name: "fake", errs: test.errs}
+			result := deliverToSink(ConfiguredSink{Sink: sink, Policy: policy}, Event{})
+
+			want := SinkResult{Sink: "fake", Sent: test.sent, Attempts: test.attempts, Error: test.err}
+			if result != want {
+				t.Errorf("deliverToSink() = %+v, want %+v", result, want)
+			}
+		})
+	}
+}
+
+func TestDeliverToSinksIndependently(t *testing.T) {
+	policy := DeliveryPolicy{Timeout: 20 * time.Millisecond, Retries: 0, RetryBackoff: time.Millisecond}
+	sinks := []ConfiguredSink{
+		{Sink: &fakeSink{name: "blocked", errs: []error{errBlock}}, Policy: policy},
+		{Sink: &fakeSink{name: "broken", errs: []error{errPanic}}, Policy: policy},
+		{Sink: &fakeSink{name: "working"}, Policy: policy},
+	}
+
+	results := deliverToSinks(sinks, Event{})
+
+	if len(results) != 3 || results[0].Sink != "blocked" || results[1].Sink != "broken" || results[2].Sink != "working" {
+		t.Fatalf("deliverToSinks() = %+v, want a result per sink, in order", results)
+	}
+	if results[0].Sent || results[0].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("blocked = %+v, want it to time out", results[0])
+	}
+	if results[1].Sent || results[1].Error != "panic: sink bug" {
+		t.Errorf("broken = %+v, want the panic as its error", results[1])
+	}
+	if !results[2].Sent {
+		t.Errorf("working = %+v, want it sent", results[2])
+	}
+}
+
+func TestGetDeliveryStatusCode(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []SinkResult
+		want    int
+	}{
+		{"all sent", []SinkResult{{Sent: true}, {Sent: true}}, http.StatusOK},
+		{"some sent", []SinkResult{{Sent: true}, {Sent: false}}, http.StatusMultiStatus},
+		{"none sent", []SinkResult{{Sent: false}, {Sent: false}}, http.StatusBadGateway},
+	}
+
+	for _, test := range tests {
+		if got := getDeliveryStatusCode(test.results); got != test.want {
+			t.Errorf("%s: getDeliveryStatusCode() = %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFStatusCodes(t *testing.T) {
+	noRetries := 0
+	config := newTestConfig()
+	config.Discord.Retries = &noRetries
+	config.Sinks = []SinkConfig{{Type: "discord", WebhookURL: "https://discord.com/api/webhooks/2/token", Retries: &noRetries}}
+	defer useTestConfig(&config)()
+
+	// The primary Discord webhook is 1, and the sink is 2. The Clubhouse API always fails.
+	var discordStatusCode, discordSinkStatusCode int
+	http.DefaultClient.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		statusCode := http.StatusInternalServerError
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/api/webhooks/1/"):
+			statusCode = discordStatusCode
+		case strings.HasPrefix(r.URL.Path, "/api/webhooks/2/"):
+			statusCode = discordSinkStatusCode
+		}
+		return &http.Response{StatusCode: statusCode, Body: ioutil.NopCloser(strings.NewReader("")), Request: r}, nil
+	})
+	defer func() { http.DefaultClient.Transport = nil }()
+
+	deleted := `{"version": "v1", "actions": [{"id": 1, "entity_type": "story", "action": "delete", "name": "Fix the login page", "app_url": "https://app.clubhouse.io/workspace/story/1"}]}`
+	tests := []struct {
+		name          string
+		data          string
+		discord       int
+		discordSink   int
+		want          int
+		wantSinksSent []bool
+	}{
+		{"all sent", deleted, http.StatusNoContent, http.StatusNoContent, http.StatusOK, []bool{true, true}},
+		{"Discord failed", deleted, http.StatusBadRequest, http.StatusNoContent, http.StatusMultiStatus, []bool{false, true}},
+		{"sink failed", deleted, http.StatusNoContent, http.StatusServiceUnavailable, http.StatusMultiStatus, []bool{true, false}},
+		{"all failed", deleted, http.StatusBadGateway, http.StatusBadRequest, http.StatusBadGateway, []bool{false, false}},
+		{"unhandled", `{"version": "v1", "actions": []}`, http.StatusNoContent, http.StatusNoContent, http.StatusOK, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			discordStatusCode, discordSinkStatusCode = test.discord, test.discordSink
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.data))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			F(w, r)
+
+			if w.Code != test.want {
+				t.Fatalf("F() = %d %q, want %d", w.Code, w.Body.String(), test.want)
+			}
+			if test.wantSinksSent == nil {
+				return
+			}
+
+			var response struct {
+				Sinks []SinkResult `json:"sinks"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			sinksSent := make([]bool, len(response.Sinks))
+			for i, result := range response.Sinks {
+				sinksSent[i] = result.Sent
+			}
+			if !reflect.DeepEqual(sinksSent, test.wantSinksSent) {
+				t.Errorf("sinks = %+v, want sent %v", response.Sinks, test.wantSinksSent)
+			}
+		})
+	}
+}
+
+func TestFRespondsWithAnErrorWhenAWebhookCannotBeForwarded(t *testing.T) {
+	config := newTestConfig()
+	config.Filters.CustomFields = []CustomFieldRule{{Field: "Priority", Value: "High"}}
+	defer useTestConfig(&config)()
+
+	http.DefaultClient.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusInternalServerError, Body: ioutil.NopCloser(strings.NewReader("")), Request: r}, nil
+	})
+	defer func() { http.DefaultClient.Transport = nil }()
+
+	// The story's custom fields have to be fetched to filter it, which fails.
+	data := `{"version": "v1", "actions": [{"id": 1, "entity_type": "story", "action": "update", "name": "Fix the login page", "changes": {"name": {"old": "a", "new": "b"}}}]}`
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	F(w, r)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("F() = %d %q, want 500", w.Code, w.Body.String())
+	}
+}
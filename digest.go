@@ -0,0 +1,486 @@
+package function
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A DigestChannel is a Discord webhook that receives a scheduled summary instead of a stream of events.
+type DigestChannel struct {
+	Name       string `json:"name"`
+	WebhookURL string `json:"webhook_url"`
+	// A 5 field cron expression, e.g. "0 9 * * 1-5" for 9am on weekdays.
+	Schedule string `json:"schedule"`
+	// An IANA timezone for the schedule. Defaults to UTC.
+	Timezone string `json:"timezone,omitempty"`
+
+	schedule *CronSchedule
+	location *time.Location
+}
+
+const (
+	DigestKind_Created    = "created"
+	DigestKind_Completed  = "completed"
+	DigestKind_Moved      = "moved"
+	DigestKind_Reassigned = "reassigned"
+	DigestKind_Updated    = "updated"
+)
+
+var digestKinds = []string{
+	DigestKind_Created,
+	DigestKind_Completed,
+	DigestKind_Moved,
+	DigestKind_Reassigned,
+	DigestKind_Updated,
+}
+
+type DigestEntry struct {
+	Kind       string    `json:"kind"`
+	ReceivedAt time.Time `json:"received_at"`
+	StoryID    int       `json:"story_id"`
+	StoryName  string    `json:"story_name"`
+	StoryURL   string    `json:"story_url"`
+	Project    string    `json:"project"`
+	Epic       string    `json:"epic"`
+	// e.g. the workflow state change of a moved story.
+	Detail string `json:"detail,omitempty"`
+	// e.g. the owners added to a reassigned story, which are looked up when the digest is posted.
+	MemberIDs []string `json:"member_ids,omitempty"`
+}
+
+type digestBuffer struct {
+	LastPostedAt time.Time     `json:"last_posted_at"`
+	Entries      []DigestEntry `json:"entries"`
+}
+
+// FileDigestStore stores digest entries as a file per channel in a directory.
+type FileDigestStore struct {
+	Dir string
+}
+
+// prepareDigestChannels validates the channels, and parses their schedules and timezones.
+func prepareDigestChannels(channels []DigestChannel) ([]DigestChannel, error) {
+	prepared := make([]DigestChannel, len(channels))
+	copy(prepared, channels)
+
+	for i := range prepared {
+		if prepared[i].WebhookURL == "" {
+			return nil, fmt.Errorf("digest channel is missing a webhook URL: %q", prepared[i].Name)
+		}
+
+		schedule, err := ParseCronSchedule(prepared[i].Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("digest channel %q: %v", prepared[i].Name, err)
+		}
+		prepared[i].schedule = schedule
+
+		prepared[i].location = time.UTC
+		if prepared[i].Timezone != "" {
+			prepared[i].location, err = time.LoadLocation(prepared[i].Timezone)
+			if err != nil {
+				return nil, fmt.Errorf("digest channel %q: %v", prepared[i].Name, err)
+			}
+		}
+	}
+
+	return prepared, nil
+}
+
+func findDigestChannel(channels []DigestChannel, webhookURL string) (DigestChannel, bool) {
+	for _, channel := range channels {
+		if channel.WebhookURL == webhookURL {
+			return channel, true
+		}
+	}
+
+	return DigestChannel{}, false
+}
+
+func (s *FileDigestStore) getPath(channel DigestChannel) string {
+	// The webhook URL contains a token, so it is hashed rather than used as is.
+	hash := sha256.Sum256([]byte(channel.WebhookURL))
+	return filepath.Join(s.Dir, hex.EncodeToString(hash[:8])+".json")
+}
+
+func (s *FileDigestStore) read(channel DigestChannel) (digestBuffer, error) {
+	var buffer digestBuffer
+
+	data, err := ioutil.ReadFile(s.getPath(channel))
+	if os.IsNotExist(err) {
+		return buffer, nil
+	}
+	if err != nil {
+		return buffer, err
+	}
+
+	err = json.Unmarshal(data, &buffer)
+	return buffer, err
+}
+
+func (s *FileDigestStore) write(channel DigestChannel, buffer digestBuffer) error {
+	data, err := json.Marshal(buffer)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.getPath(channel), data)
+}
+
+func (s *FileDigestStore) Add(channel DigestChannel, entries []DigestEntry) error {
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	buffer, err := s.read(channel)
+	if err != nil {
+		return err
+	}
+
+	buffer.Entries = append(buffer.Entries, entries...)
+
+	return s.write(channel, buffer)
+}
+
+// TakeIfDue removes and returns the entries of a channel if its schedule has matched since it was last posted.
+func (s *FileDigestStore) TakeIfDue(channel DigestChannel, now time.Time, force bool) ([]DigestEntry, time.Time, bool, error) {
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+	defer unlock()
+
+	buffer, err := s.read(channel)
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+
+	since := buffer.LastPostedAt
+	if since.IsZero() {
+		// The first digest covers everything since the channel was set up.
+		buffer.LastPostedAt = now
+		if len(buffer.Entries) > 0 {
+			buffer.LastPostedAt = buffer.Entries[0].ReceivedAt
+		}
+		since = buffer.LastPostedAt
+	}
+
+	if !force && !channel.schedule.MatchesBetween(buffer.LastPostedAt.In(channel.location), now.In(channel.location)) {
+		return nil, since, false, s.write(channel, buffer)
+	}
+
+	entries := buffer.Entries
+	buffer.Entries = nil
+	buffer.LastPostedAt = now
+
+	return entries, since, true, s.write(channel, buffer)
+}
+
+// getDigestEntries summarises a story webhook for a digest.
+func getDigestEntries(
+	clubhouseApiClient *ClubhouseApiClient,
+	webhook ClubhouseWebhook,
+	receivedAt time.Time,
+) ([]DigestEntry, error) {
+	storyAction, ok := findAction(webhook, "story")
+	if !ok || storyAction.AppURL == "" {
+		return nil, nil
+	}
+
+	referencesByTypeID := getReferencesByTypeID(webhook)
+
+	projectID := storyAction.ProjectID
+	epicID := storyAction.EpicID
+	if projectID == 0 && storyAction.Action == "update" {
+		story, err := clubhouseApiClient.GetStory(storyAction.ID)
+		if err != nil {
+			return nil, err
+		}
+		projectID = story.ProjectID
+		if story.EpicID != nil {
+			epicID = *story.EpicID
+		}
+	}
+
+	entry := DigestEntry{
+		ReceivedAt: receivedAt,
+		StoryID:    storyAction.ID,
+		StoryName:  storyAction.Name,
+		StoryURL:   storyAction.AppURL,
+		Project:    "No Project",
+		Epic:       "No Epic",
+	}
+	// Stories moved between projects or epics do not include the new one in their references.
+	if projectID > 0 {
+		entry.Project = resolveReferenceName(clubhouseApiClient, referencesByTypeID, "project", projectID)
+	}
+	if epicID > 0 {
+		entry.Epic = resolveReferenceName(clubhouseApiClient, referencesByTypeID, "epic", epicID)
+	}
+
+	var entries []DigestEntry
+	changes := storyAction.Changes
+
+	switch storyAction.Action {
+	case "create":
+		entry.Kind = DigestKind_Created
+		entries = append(entries, entry)
+	case "update":
+		if changes.Completed != nil && changes.Completed.New {
+			completedEntry := entry
+			completedEntry.Kind = DigestKind_Completed
+			entries = append(entries, completedEntry)
+		} else if changes.WorkflowStateID != nil {
+			movedEntry := entry
+			movedEntry.Kind = DigestKind_Moved
+			movedEntry.Detail = fmt.Sprintf(
+				"%s -> %s",
+				resolveReferenceName(clubhouseApiClient, referencesByTypeID, "workflow-state", changes.WorkflowStateID.Old),
+				resolveReferenceName(clubhouseApiClient, referencesByTypeID, "workflow-state", changes.WorkflowStateID.New),
+			)
+			entries = append(entries, movedEntry)
+		}
+
+		if changes.OwnerIds != nil && len(changes.OwnerIds.Adds) > 0 {
+			reassignedEntry := entry
+			reassignedEntry.Kind = DigestKind_Reassigned
+			reassignedEntry.MemberIDs = changes.OwnerIds.Adds
+			entries = append(entries, reassignedEntry)
+		}
+
+		if len(entries) == 0 {
+			entry.Kind = DigestKind_Updated
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// A digestMessage is one of the messages a digest is posted as, with the entries it covers.
+type digestMessage struct {
+	Event   Event
+	Entries []DigestEntry
+}
+
+// toDigestMessages renders an item per project, with the stories grouped by epic. Digests with more projects
+// than fit in a message are split across several.
+func toDigestMessages(clubhouseApiClient *ClubhouseApiClient, channelName string, entries []DigestEntry, since time.Time) ([]digestMessage, error) {
+	totalsByKind := make(map[string]int)
+	entriesByProject := make(map[string][]DigestEntry)
+	var projects []string
+
+	for _, entry := range entries {
+		totalsByKind[entry.Kind]++
+		if _, ok := entriesByProject[entry.Project]; !ok {
+			projects = append(projects, entry.Project)
+		}
+		entriesByProject[entry.Project] = append(entriesByProject[entry.Project], entry)
+	}
+	sort.Strings(projects)
+
+	var totals []string
+	for _, kind := range digestKinds {
+		if totalsByKind[kind] > 0 {
+			totals = append(totals, fmt.Sprintf("%d %s", totalsByKind[kind], kind))
+		}
+	}
+
+	content := concatText(boldText("Digest since"), plainText(" "), timeText(TextSpanKind_DateTime, since))
+	if len(totals) == 0 {
+		content = concatText(content, plainText("\nNo story activity."))
+	} else {
+		content = concatText(content, plainText("\n"+strings.Join(totals, ", ")))
+	}
+
+	messages := []digestMessage{{Event: Event{Content: content}}}
+	for _, project := range projects {
+		message := &messages[len(messages)-1]
+		if len(message.Event.Items) == maxEmbeds {
+			messages = append(messages, digestMessage{})
+			message = &messages[len(messages)-1]
+		}
+
+		fields, err := getDigestFields(clubhouseApiClient, entriesByProject[project])
+		if err != nil {
+			return nil, err
+		}
+
+		message.Event.Items = append(message.Event.Items, EventItem{
+			Title:  project,
+			Colour: 5424154,
+			Fields: fields,
+		})
+		message.Entries = append(message.Entries, entriesByProject[project]...)
+	}
+
+	// Identical digests of different periods are different events.
+	for i := range messages {
+		messages[i].Event.ID = fmt.Sprintf("digest:%s:%d:%d", channelName, since.Unix(), i)
+	}
+
+	return messages, nil
+}
+
+// getDigestFields renders a field per epic, listing its stories by kind of activity.
+func getDigestFields(clubhouseApiClient *ClubhouseApiClient, entries []DigestEntry) ([]EventField, error) {
+	entriesByEpic := make(map[string][]DigestEntry)
+	var epics []string
+
+	for _, entry := range entries {
+		if _, ok := entriesByEpic[entry.Epic]; !ok {
+			epics = append(epics, entry.Epic)
+		}
+		entriesByEpic[entry.Epic] = append(entriesByEpic[entry.Epic], entry)
+	}
+	sort.Strings(epics)
+
+	var fields []EventField
+
+	for _, epic := range epics {
+		var lines []Text
+
+		for _, kind := range digestKinds {
+			var stories []Text
+			seenStoryIDs := make(map[int]bool)
+
+			for _, entry := range entriesByEpic[epic] {
+				if entry.Kind != kind || seenStoryIDs[entry.StoryID] {
+					continue
+				}
+				seenStoryIDs[entry.StoryID] = true
+
+				story := linkText(entry.StoryName, entry.StoryURL)
+				if entry.Detail != "" {
+					story = concatText(story, plainText(fmt.Sprintf(" (%s)", entry.Detail)))
+				}
+				if len(entry.MemberIDs) > 0 {
+					owners, err := getMemberNames(clubhouseApiClient, entry.MemberIDs)
+					if err != nil {
+						return nil, err
+					}
+					story = concatText(story, plainText(fmt.Sprintf(" (to %s)", strings.Join(owners, ", "))))
+				}
+				stories = append(stories, story)
+			}
+
+			if len(stories) > 0 {
+				heading := boldText(fmt.Sprintf("%s (%d):", strings.Title(kind), len(stories)))
+				lines = append(lines, concatText(heading, plainText(" "), joinText(stories, ", ")))
+			}
+		}
+
+		fields = append(fields, EventField{
+			Name:  epic,
+			Value: joinText(lines, "\n"),
+		})
+	}
+
+	return fields, nil
+}
+
+// postDigest posts a digest, and returns the entries of the messages that could not be posted.
+func postDigest(clubhouseApiClient *ClubhouseApiClient, channel DigestChannel, entries []DigestEntry, since time.Time) ([]DigestEntry, error) {
+	messages, err := toDigestMessages(clubhouseApiClient, channel.Name, entries, since)
+	if err != nil {
+		return entries, err
+	}
+
+	for i, message := range messages {
+		if err := postDigestMessage(channel, message.Event); err != nil {
+			var unposted []DigestEntry
+			for _, message := range messages[i:] {
+				unposted = append(unposted, message.Entries...)
+			}
+			return unposted, err
+		}
+	}
+
+	return nil, nil
+}
+
+func postDigestMessage(channel DigestChannel, event Event) error {
+	payload, err := json.Marshal(toDiscordWebhook(event))
+	if err != nil {
+		return err
+	}
+
+	res, err := http.Post(channel.WebhookURL, "application/json", bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		log.Println("payload", string(payload))
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// PostDueDigests posts the digest of every channel whose schedule has matched since its last digest.
+// If channelName is set, only that channel's digest is posted, whether or not it is due.
+func PostDueDigests(channelName string) {
+	for _, env := range getEnvironments() {
+		postDueDigests(env, channelName)
+	}
+}
+
+func postDueDigests(env environment, channelName string) {
+	clubhouseApiClient := env.newClubhouseApiClient()
+	digestStore := &FileDigestStore{Dir: env.DigestStoreDir}
+	now := time.Now()
+
+	for _, channel := range env.DigestChannels {
+		if channelName != "" && channel.Name != channelName {
+			continue
+		}
+
+		entries, since, ok, err := digestStore.TakeIfDue(channel, now, channelName != "")
+		if err != nil {
+			log.Printf("\nfailed to take digest for %q: %s \n", channel.Name, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		unposted, err := postDigest(clubhouseApiClient, channel, entries, since)
+		if err != nil {
+			log.Printf("\nfailed to post digest for %q: %s \n", channel.Name, err)
+
+			// Put the entries that were not posted back, so that they are included in the next digest.
+			if err := digestStore.Add(channel, unposted); err != nil {
+				log.Printf("\nfailed to restore digest for %q: %s \n", channel.Name, err)
+			}
+		}
+	}
+}
+
+// Digest is an HTTP entry point for PostDueDigests, to be triggered every minute (e.g. by Cloud Scheduler).
+// The "channel" query parameter posts a channel's digest immediately.
+func Digest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		log.Printf("\ninvalid method: %s \n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("invalid request"))
+		return
+	}
+
+	PostDueDigests(r.URL.Query().Get("channel"))
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,129 @@
+package function
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestToDiscordDigestSplitsProjects(t *testing.T) {
+	var entries []DigestEntry
+	for i := 0; i < maxEmbeds+2; i++ {
+		entries = append(entries, DigestEntry{
+			Kind:      DigestKind_Created,
+			StoryID:   i,
+			StoryName: fmt.Sprintf("Story %d", i),
+			StoryURL:  fmt.Sprintf("https://app.clubhouse.io/workspace/story/%d", i),
+			Project:   fmt.Sprintf("Project %02d", i),
+			Epic:      "No Epic",
+		})
+	}
+
+	since := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	messages, err := toDigestMessages(&ClubhouseApiClient{ApiToken: t.Name()}, "daily", entries, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("toDigestMessages() = %d messages, want 2", len(messages))
+	}
+	if len(messages[0].Event.Items) != maxEmbeds || len(messages[1].Event.Items) != 2 {
+		t.Errorf("embeds = %d, %d, want %d, 2", len(messages[0].Event.Items), len(messages[1].Event.Items), maxEmbeds)
+	}
+	if len(messages[0].Event.Content) == 0 {
+		t.Error("the first message has no summary")
+	}
+	if len(messages[1].Entries) != 2 || messages[1].Entries[0].Project != "Project 10" {
+		t.Errorf("entries of the second message = %+v, want those of Project 10 and 11", messages[1].Entries)
+	}
+	if messages[0].Event.ID != "digest:daily:1614589200:0" || messages[1].Event.ID != "digest:daily:1614589200:1" {
+		t.Errorf("IDs = %q, %q, want the channel, period and part", messages[0].Event.ID, messages[1].Event.ID)
+	}
+}
+
+func TestGetDigestEntriesResolvesMissingReferences(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/projects/5" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": 5, "name": "Mobile"}`))
+	}))
+	defer server.Close()
+
+	var webhook ClubhouseWebhook
+	err := json.Unmarshal([]byte(`{
+		"actions": [{
+			"id": 1,
+			"entity_type": "story",
+			"action": "update",
+			"name": "Fix the login page",
+			"app_url": "https://app.clubhouse.io/workspace/story/1",
+			"project_id": 5,
+			"changes": {"workflow_state_id": {"old": 10, "new": 11}}
+		}],
+		"references": [
+			{"id": 10, "entity_type": "workflow-state", "name": "To Do"},
+			{"id": 11, "entity_type": "workflow-state", "name": "Doing"}
+		]
+	}`), &webhook)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: t.Name(), BaseURL: server.URL}
+	entries, err := getDigestEntries(clubhouseApiClient, webhook, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Kind != DigestKind_Moved || entries[0].Project != "Mobile" || entries[0].Detail != "To Do -> Doing" {
+		t.Errorf("getDigestEntries() = %+v", entries)
+	}
+}
+
+func TestFileDigestStoreTakeIfDue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "digest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	channels, err := prepareDigestChannels([]DigestChannel{{
+		Name:       "managers",
+		WebhookURL: "https://discord.com/api/webhooks/1/token",
+		Schedule:   "0 9 * * *",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	channel := channels[0]
+	store := &FileDigestStore{Dir: dir}
+
+	received := time.Date(2021, 3, 1, 8, 0, 0, 0, time.UTC)
+	if err := store.Add(channel, []DigestEntry{{Kind: DigestKind_Created, ReceivedAt: received, StoryID: 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if entries, _, ok, err := store.TakeIfDue(channel, received.Add(30*time.Minute), false); err != nil || ok || entries != nil {
+		t.Fatalf("TakeIfDue() before 9am = %v, %t, %v, want nothing", entries, ok, err)
+	}
+
+	entries, since, ok, err := store.TakeIfDue(channel, received.Add(time.Hour), false)
+	if err != nil || !ok || len(entries) != 1 || !since.Equal(received) {
+		t.Fatalf("TakeIfDue() at 9am = %v, %s, %t, %v, want the entry since 8am", entries, since, ok, err)
+	}
+
+	if entries, _, ok, err := store.TakeIfDue(channel, received.Add(2*time.Hour), false); err != nil || ok || entries != nil {
+		t.Errorf("TakeIfDue() after posting = %v, %t, %v, want nothing", entries, ok, err)
+	}
+	if _, _, ok, err := store.TakeIfDue(channel, received.Add(2*time.Hour), true); err != nil || !ok {
+		t.Errorf("TakeIfDue() forced = %t, %v, want it to be due", ok, err)
+	}
+}
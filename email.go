@@ -0,0 +1,542 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The kinds of events that email routes can select.
+var emailEventKinds = []string{"blocked", "story-completed", "epic-completed"}
+
+// EmailRoute emails events of some kinds (e.g. "blocked") to more recipients.
+type EmailRoute struct {
+	Events []string `json:"events"`
+	To     []string `json:"to"`
+}
+
+// EmailSink emails events over SMTP, with an HTML and a plain text part. With a batch window, events are
+// collected and sent together (one email per set of recipients) once the oldest is that old.
+type EmailSink struct {
+	SinkName     string
+	SMTPHost     string
+	SMTPUsername string
+	SMTPPassword string
+	From         string
+	To           []string
+	Routes       []EmailRoute
+	BatchWindow  time.Duration
+	BatchDir     string
+}
+
+type emailBatch struct {
+	Since  time.Time      `json:"since"`
+	Emails []pendingEmail `json:"emails"`
+}
+
+type pendingEmail struct {
+	To    []string `json:"to"`
+	Event Event    `json:"event"`
+}
+
+func (s *EmailSink) Name() string {
+	return s.SinkName
+}
+
+func (s *EmailSink) Send(ctx context.Context, event Event) error {
+	to := s.getRecipients(event)
+	if len(to) == 0 {
+		return nil
+	}
+
+	if s.BatchWindow <= 0 {
+		return s.sendEmail(ctx, to, []Event{event})
+	}
+
+	if err := s.addToBatch(pendingEmail{To: to, Event: event}, time.Now()); err != nil {
+		return err
+	}
+
+	// The event is batched, so failing to flush is not a failure to send it (and it must not be retried).
+	if err := s.Flush(ctx, time.Now(), false); err != nil {
+		log.Println("failed to flush sink", s.SinkName+":", err)
+	}
+
+	return nil
+}
+
+// getRecipients returns the sink's recipients, and those of the routes matching the event, without duplicates.
+func (s *EmailSink) getRecipients(event Event) []string {
+	seen := make(map[string]bool)
+	var to []string
+
+	add := func(addresses []string) {
+		for _, address := range addresses {
+			if !seen[address] {
+				seen[address] = true
+				to = append(to, address)
+			}
+		}
+	}
+
+	add(s.To)
+	for _, route := range s.Routes {
+		if matchEventKinds(route.Events, event.Kinds) {
+			add(route.To)
+		}
+	}
+
+	sort.Strings(to)
+	return to
+}
+
+func matchEventKinds(routeKinds []string, eventKinds []string) bool {
+	for _, routeKind := range routeKinds {
+		for _, eventKind := range eventKinds {
+			if routeKind == eventKind {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *EmailSink) getBatchPath() string {
+	return filepath.Join(s.BatchDir, s.SinkName+".json")
+}
+
+func (s *EmailSink) readBatch() (emailBatch, error) {
+	var batch emailBatch
+
+	data, err := ioutil.ReadFile(s.getBatchPath())
+	if os.IsNotExist(err) {
+		return batch, nil
+	}
+	if err != nil {
+		return batch, err
+	}
+
+	err = json.Unmarshal(data, &batch)
+	return batch, err
+}
+
+func (s *EmailSink) writeBatch(batch emailBatch) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.getBatchPath(), data)
+}
+
+func (s *EmailSink) addToBatch(emails pendingEmail, now time.Time) error {
+	unlock, err := lockDir(s.BatchDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	batch, err := s.readBatch()
+	if err != nil {
+		return err
+	}
+
+	if len(batch.Emails) == 0 {
+		batch.Since = now
+	}
+	batch.Emails = append(batch.Emails, emails)
+
+	return s.writeBatch(batch)
+}
+
+// takeBatch removes and returns the pending emails, if the batch window has passed (or force is set).
+func (s *EmailSink) takeBatch(now time.Time, force bool) ([]pendingEmail, error) {
+	unlock, err := lockDir(s.BatchDir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	batch, err := s.readBatch()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(batch.Emails) == 0 || (!force && now.Sub(batch.Since) < s.BatchWindow) {
+		return nil, nil
+	}
+
+	if err := s.writeBatch(emailBatch{}); err != nil {
+		return nil, err
+	}
+
+	return batch.Emails, nil
+}
+
+// Flush sends the batched events, if the batch window has passed (or force is set). Events that fail to
+// send are put back in the batch.
+func (s *EmailSink) Flush(ctx context.Context, now time.Time, force bool) error {
+	if s.BatchWindow <= 0 {
+		return nil
+	}
+
+	emails, err := s.takeBatch(now, force)
+	if err != nil {
+		return err
+	}
+
+	var keys []string
+	eventsByKey := make(map[string][]pendingEmail)
+	for _, email := range emails {
+		key := strings.Join(email.To, ",")
+		if _, ok := eventsByKey[key]; !ok {
+			keys = append(keys, key)
+		}
+		eventsByKey[key] = append(eventsByKey[key], email)
+	}
+
+	var failures []string
+	for _, key := range keys {
+		events := make([]Event, len(eventsByKey[key]))
+		for i, email := range eventsByKey[key] {
+			events[i] = email.Event
+		}
+
+		if err := s.sendEmail(ctx, eventsByKey[key][0].To, events); err != nil {
+			failures = append(failures, err.Error())
+			for _, email := range eventsByKey[key] {
+				if err := s.addToBatch(email, now); err != nil {
+					log.Println("failed to put back batched email:", err)
+				}
+			}
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to send batched emails: %s", strings.Join(failures, "; "))
+	}
+
+	return nil
+}
+
+// sendEmail is smtp.SendMail, giving up when the context is done.
+func (s *EmailSink) sendEmail(ctx context.Context, to []string, events []Event) error {
+	message, err := newEmailMessage(s.From, to, events, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// The envelope only has the addresses, without names.
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+	recipients := make([]string, len(to))
+	for i, address := range to {
+		recipient, err := mail.ParseAddress(address)
+		if err != nil {
+			return err
+		}
+		recipients[i] = recipient.Address
+	}
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", s.SMTPHost)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	host, _, _ := net.SplitHostPort(s.SMTPHost)
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if s.SMTPUsername != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.SMTPUsername, s.SMTPPassword, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+
+	dataWriter, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := dataWriter.Write(message); err != nil {
+		return err
+	}
+	if err := dataWriter.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func validateEmailSinkConfig(config SinkConfig) error {
+	if _, _, err := net.SplitHostPort(config.SMTPHost); err != nil {
+		return fmt.Errorf("smtp_host: must be a host and port, e.g. smtp.example.com:587, got %q", config.SMTPHost)
+	}
+
+	if _, err := mail.ParseAddress(config.From); err != nil {
+		return fmt.Errorf("from: must be an email address: %v", err)
+	}
+
+	if len(config.To) == 0 && len(config.Routes) == 0 {
+		return fmt.Errorf("to: is required without routes")
+	}
+
+	for i, address := range config.To {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("to[%d]: must be an email address: %v", i, err)
+		}
+	}
+
+	for i, route := range config.Routes {
+		if len(route.Events) == 0 {
+			return fmt.Errorf("routes[%d].events: is required", i)
+		}
+		for _, kind := range route.Events {
+			if !matchEventKinds(emailEventKinds, []string{kind}) {
+				return fmt.Errorf("routes[%d].events: must be one of %s, got %q", i, strings.Join(emailEventKinds, ", "), kind)
+			}
+		}
+
+		if len(route.To) == 0 {
+			return fmt.Errorf("routes[%d].to: is required", i)
+		}
+		for j, address := range route.To {
+			if _, err := mail.ParseAddress(address); err != nil {
+				return fmt.Errorf("routes[%d].to[%d]: must be an email address: %v", i, j, err)
+			}
+		}
+	}
+
+	if config.BatchWindow != "" {
+		batchWindow, err := time.ParseDuration(config.BatchWindow)
+		if err != nil || batchWindow < 0 {
+			return fmt.Errorf("batch_window: must be a positive duration (e.g. \"15m\"), got %q", config.BatchWindow)
+		}
+	}
+
+	return nil
+}
+
+// getEventKinds returns the kinds of a webhook that email routes can select. A story is blocked when it is
+// marked as blocked, or a link is added saying another story blocks it (unlike escalations, marking a story as
+// a blocker of others does not count).
+func getEventKinds(webhook ClubhouseWebhook) []string {
+	var kinds []string
+	blocked := false
+
+	for _, action := range webhook.Actions {
+		switch {
+		case action.EntityType == "story-link" && action.Verb == "blocks" && action.Action == "create":
+			blocked = true
+		case action.EntityType == "story" && action.Changes.Blocked != nil && action.Changes.Blocked.New:
+			blocked = true
+		}
+	}
+	if blocked {
+		kinds = append(kinds, "blocked")
+	}
+
+	for _, action := range webhook.Actions {
+		if action.Changes.Completed == nil || !action.Changes.Completed.New {
+			continue
+		}
+
+		switch action.EntityType {
+		case "story":
+			kinds = append(kinds, "story-completed")
+		case "epic":
+			kinds = append(kinds, "epic-completed")
+		}
+	}
+
+	return kinds
+}
+
+// newEmailMessage renders events as a multipart/alternative email, with a plain text and an HTML part.
+func newEmailMessage(from string, to []string, events []Event, now time.Time) ([]byte, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", toEmailText(events)},
+		{"text/html; charset=utf-8", toEmailHTML(events)},
+	} {
+		partWriter, err := writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeQuotedPrintable(partWriter, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	var message bytes.Buffer
+	headers := [][2]string{
+		{"From", from},
+		{"To", strings.Join(to, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", getEmailSubject(events))},
+		{"Date", now.Format(time.RFC1123Z)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + writer.Boundary()},
+	}
+	for _, header := range headers {
+		fmt.Fprintf(&message, "%s: %s\r\n", header[0], header[1])
+	}
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+
+	return message.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qpWriter := quotedprintable.NewWriter(w)
+	if _, err := qpWriter.Write([]byte(content)); err != nil {
+		return err
+	}
+
+	return qpWriter.Close()
+}
+
+func getEmailSubject(events []Event) string {
+	var titles []string
+	for _, event := range events {
+		for _, item := range event.Items {
+			titles = append(titles, item.Title)
+		}
+	}
+
+	switch len(titles) {
+	case 0:
+		return "Clubhouse update"
+	case 1:
+		return titles[0]
+	default:
+		return fmt.Sprintf("%d Clubhouse updates", len(titles))
+	}
+}
+
+func toEmailText(events []Event) string {
+	var sections []string
+
+	for _, event := range events {
+		if len(event.Content) > 0 {
+			sections = append(sections, event.Content.String())
+		}
+
+		for _, item := range event.Items {
+			lines := []string{item.Title}
+			if item.URL != "" {
+				lines = append(lines, item.URL)
+			}
+			if item.Description != "" {
+				lines = append(lines, "", item.Description)
+			}
+			if len(item.Fields) > 0 {
+				lines = append(lines, "")
+			}
+			for _, field := range item.Fields {
+				lines = append(lines, fmt.Sprintf("%s: %s", field.Name, field.Value))
+			}
+
+			sections = append(sections, strings.Join(lines, "\n"))
+		}
+	}
+
+	return strings.Join(sections, "\n\n")
+}
+
+func toEmailHTML(events []Event) string {
+	var body strings.Builder
+	body.WriteString(`<!DOCTYPE html><html><body style="font-family: sans-serif;">`)
+
+	for _, event := range events {
+		if len(event.Content) > 0 {
+			body.WriteString("<p>" + toHTML(event.Content) + "</p>")
+		}
+
+		for _, item := range event.Items {
+			fmt.Fprintf(&body, `<div style="border-left: 4px solid #%06x; padding: 4px 12px; margin: 12px 0;">`, item.Colour)
+
+			title := html.EscapeString(item.Title)
+			if item.URL != "" {
+				title = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(item.URL), title)
+			}
+			body.WriteString(`<h3 style="margin: 4px 0;">` + title + "</h3>")
+
+			if item.Description != "" {
+				body.WriteString("<p>" + toHTML(plainText(item.Description)) + "</p>")
+			}
+
+			if len(item.Fields) > 0 {
+				body.WriteString("<table>")
+				for _, field := range item.Fields {
+					fmt.Fprintf(
+						&body,
+						`<tr><th style="text-align: left; vertical-align: top; padding-right: 12px;">%s</th><td>%s</td></tr>`,
+						html.EscapeString(field.Name),
+						toHTML(field.Value),
+					)
+				}
+				body.WriteString("</table>")
+			}
+
+			body.WriteString("</div>")
+		}
+	}
+
+	body.WriteString("</body></html>")
+	return body.String()
+}
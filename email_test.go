@@ -0,0 +1,278 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Courtsite/clubhouse-to-discord/internal/smtpstub"
+)
+
+// newSMTPStub starts a stub SMTP server, returning its address and the messages it has received.
+func newSMTPStub(t *testing.T) (string, func() []smtpstub.Message, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mutex sync.Mutex
+	var messages []smtpstub.Message
+	go smtpstub.Serve(listener, func(message smtpstub.Message) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		messages = append(messages, message)
+	})
+
+	getMessages := func() []smtpstub.Message {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]smtpstub.Message(nil), messages...)
+	}
+
+	return listener.Addr().String(), getMessages, func() { listener.Close() }
+}
+
+func getEmailSubjectHeader(t *testing.T, message smtpstub.Message) string {
+	parsed, err := mail.ReadMessage(strings.NewReader(message.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return subject
+}
+
+func newTestEmailEvent(title string, kinds ...string) Event {
+	return Event{
+		Items: []EventItem{{Title: title, Fields: []EventField{{Name: "State", Value: plainText("In Review")}}}},
+		Kinds: kinds,
+	}
+}
+
+func TestEmailSinkSend(t *testing.T) {
+	addr, getMessages, stop := newSMTPStub(t)
+	defer stop()
+
+	sink := &EmailSink{
+		SinkName:     "email",
+		SMTPHost:     addr,
+		SMTPUsername: "user",
+		SMTPPassword: "password",
+		From:         "Clubhouse <clubhouse@example.com>",
+		To:           []string{"team@example.com"},
+		Routes:       []EmailRoute{{Events: []string{"blocked"}, To: []string{"Managers <managers@example.com>"}}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := sink.Send(ctx, newTestEmailEvent("Alice updated story: Login")); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	if err := sink.Send(ctx, newTestEmailEvent("Alice blocked story: Signup", "blocked")); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	messages := getMessages()
+	if len(messages) != 2 {
+		t.Fatalf("messages = %d, want 2", len(messages))
+	}
+
+	if messages[0].From != "clubhouse@example.com" || !reflect.DeepEqual(messages[0].To, []string{"team@example.com"}) {
+		t.Errorf("envelope = %s to %v, want the sink's recipients", messages[0].From, messages[0].To)
+	}
+	if subject := getEmailSubjectHeader(t, messages[0]); subject != "Alice updated story: Login" {
+		t.Errorf("Subject = %q, want the title", subject)
+	}
+	for _, part := range []string{"Content-Type: text/plain", "Content-Type: text/html", "State: In Review"} {
+		if !strings.Contains(messages[0].Data, part) {
+			t.Errorf("message = %q, want it to contain %q", messages[0].Data, part)
+		}
+	}
+
+	if !reflect.DeepEqual(messages[1].To, []string{"managers@example.com", "team@example.com"}) {
+		t.Errorf("recipients = %v, want the route's recipients too", messages[1].To)
+	}
+}
+
+func TestEmailSinkBatches(t *testing.T) {
+	addr, getMessages, stop := newSMTPStub(t)
+	defer stop()
+
+	dir, err := ioutil.TempDir("", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &EmailSink{
+		SinkName:    "email",
+		SMTPHost:    addr,
+		From:        "clubhouse@example.com",
+		To:          []string{"team@example.com"},
+		BatchWindow: time.Hour,
+		BatchDir:    dir,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, title := range []string{"Alice updated story: Login", "Bob updated story: Signup"} {
+		if err := sink.Send(ctx, newTestEmailEvent(title)); err != nil {
+			t.Fatalf("Send() = %v", err)
+		}
+	}
+	if messages := getMessages(); len(messages) != 0 {
+		t.Fatalf("messages = %d, want the events to be batched", len(messages))
+	}
+
+	if err := sink.Flush(ctx, time.Now(), false); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	if messages := getMessages(); len(messages) != 0 {
+		t.Fatalf("messages = %d, want none before the batch window has passed", len(messages))
+	}
+
+	if err := sink.Flush(ctx, time.Now().Add(2*time.Hour), false); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	messages := getMessages()
+	if len(messages) != 1 {
+		t.Fatalf("messages = %d, want the batch in one email", len(messages))
+	}
+	if subject := getEmailSubjectHeader(t, messages[0]); subject != "2 Clubhouse updates" {
+		t.Errorf("Subject = %q, want the number of updates", subject)
+	}
+
+	// The batch is empty once sent.
+	if err := sink.Flush(ctx, time.Now().Add(4*time.Hour), true); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	if messages := getMessages(); len(messages) != 1 {
+		t.Errorf("messages = %d, want the batch to be sent once", len(messages))
+	}
+}
+
+func TestEmailSinkPutsBackBatchesThatFailToSend(t *testing.T) {
+	dir, err := ioutil.TempDir("", "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Nothing listens on the address once the listener is closed.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener.Close()
+
+	sink := &EmailSink{
+		SinkName:    "email",
+		SMTPHost:    listener.Addr().String(),
+		From:        "clubhouse@example.com",
+		To:          []string{"team@example.com"},
+		BatchWindow: time.Hour,
+		BatchDir:    dir,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := sink.Send(ctx, newTestEmailEvent("Alice updated story: Login")); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+	if err := sink.Flush(ctx, time.Now(), true); err == nil {
+		t.Fatal("Flush() = nil, want an error")
+	}
+
+	batch, err := sink.readBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch.Emails) != 1 {
+		t.Errorf("batch = %+v, want the email to be put back", batch)
+	}
+}
+
+func TestGetEventKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions string
+		want    []string
+	}{
+		{
+			"blocked",
+			`[{"entity_type": "story", "action": "update", "changes": {"blocked": {"new": true, "old": false}}}]`,
+			[]string{"blocked"},
+		},
+		{
+			"unblocked",
+			`[{"entity_type": "story", "action": "update", "changes": {"blocked": {"new": false, "old": true}}}]`,
+			nil,
+		},
+		{
+			"marked as a blocker",
+			`[{"entity_type": "story", "action": "update", "changes": {"blocker": {"new": true, "old": false}}}]`,
+			nil,
+		},
+		{
+			"blocking link added",
+			`[{"entity_type": "story-link", "action": "create", "verb": "blocks", "subject_id": 1, "object_id": 2}]`,
+			[]string{"blocked"},
+		},
+		{
+			"blocking link removed",
+			`[{"entity_type": "story-link", "action": "delete", "verb": "blocks", "subject_id": 1, "object_id": 2}]`,
+			nil,
+		},
+		{
+			"completed",
+			`[{"entity_type": "story", "action": "update", "changes": {"completed": {"new": true, "old": false}}}, {"entity_type": "epic", "action": "update", "changes": {"completed": {"new": true, "old": false}}}]`,
+			[]string{"story-completed", "epic-completed"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var webhook ClubhouseWebhook
+			if err := json.Unmarshal([]byte(`{"actions": `+test.actions+`}`), &webhook); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := getEventKinds(webhook); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("getEventKinds() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSinkNamesMustBeUnique(t *testing.T) {
+	config := newTestConfig()
+	config.Sinks = []SinkConfig{
+		{Type: "email", SMTPHost: "smtp.example.com:587", From: "a@example.com", To: []string{"b@example.com"}},
+		{Type: "email", SMTPHost: "smtp.example.com:587", From: "a@example.com", To: []string{"c@example.com"}},
+	}
+
+	if _, err := config.newEnvironment(""); err == nil || !strings.Contains(err.Error(), "sinks[1].name (SINKS)") {
+		t.Errorf("newEnvironment() = %v, want an error for the second sink's name", err)
+	}
+
+	config.Sinks[1].Name = "email-managers"
+	if _, err := config.newEnvironment(""); err != nil {
+		t.Errorf("newEnvironment() = %v, want no error", err)
+	}
+}
@@ -0,0 +1,230 @@
+package function
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type environment struct {
+	// The name of the tenant, or "" for the top level settings.
+	Tenant      string
+	TenantToken string
+
+	DiscordWebhookURL           string
+	DiscordEscalationWebhookURL string
+	DiscordOptions              DiscordOptions
+	DiscordPolicy               DeliveryPolicy
+
+	ClubhouseApiToken string
+	// How long entities resolved via the API are cached for. Defaults to 10 minutes.
+	ReferenceCacheTTL time.Duration
+	// Any of these may sign webhooks, to allow rotating the secret.
+	ClubhouseWebhookSecrets []string
+	// Reject unsigned webhooks. Enabled by default when a secret is set.
+	ClubhouseWebhookStrict bool
+
+	CustomFieldFilters []CustomFieldRule
+	CustomFieldRoutes  []CustomFieldRule
+
+	// Every forwarded event is also sent to these, in addition to Discord.
+	Sinks []ConfiguredSink
+
+	// Webhooks that changed longer ago than this (or this far in the future) are rejected, as are
+	// webhooks with an ID that has already been received.
+	ReplayWindow   time.Duration
+	ReplayStoreDir string
+
+	// Successive story updates are merged until no update has been received for this long.
+	CoalesceWindow   time.Duration
+	CoalesceStoreDir string
+
+	DigestChannels []DigestChannel
+	DigestStoreDir string
+
+	StandupWebhookURL string
+	StandupSchedule   *CronSchedule
+	StandupLocation   *time.Location
+	StandupStoreDir   string
+}
+
+type cachedEnvironment struct {
+	resolvedConfig Config
+	env            environment
+	resolvedAt     time.Time
+	secretsTTL     time.Duration
+}
+
+// The configuration is loaded and validated once. Only its secrets are read again, once they have been cached
+// for SECRETS_CACHE_TTL, so that rotated secrets are picked up, and a tenant's settings are validated again
+// when they change.
+var environmentCache = struct {
+	sync.Mutex
+	config       *Config
+	envsByTenant map[string]cachedEnvironment
+}{
+	envsByTenant: make(map[string]cachedEnvironment),
+}
+
+func (env environment) newClubhouseApiClient() *ClubhouseApiClient {
+	return &ClubhouseApiClient{ApiToken: env.ClubhouseApiToken, CacheTTL: env.ReferenceCacheTTL}
+}
+
+func init() {
+	// On Cloud Functions, an invalid configuration fails the deployment, rather than the first webhook.
+	if isCloudFunction() {
+		if err := LoadEnvironments(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// LoadEnvironments loads the configuration (CONFIG_FILE, or the environment variables), and checks it and its
+// tenants, including that their secrets can be read. It is called on startup, so that the function does not
+// start with an invalid configuration.
+func LoadEnvironments() error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	if err := ValidateConfig(config); err != nil {
+		return err
+	}
+
+	environmentCache.Lock()
+	defer environmentCache.Unlock()
+
+	environmentCache.config = config
+	environmentCache.envsByTenant = make(map[string]cachedEnvironment)
+
+	return nil
+}
+
+// getTenantEnvironment returns the settings of a tenant, or the top level settings for "". If its secrets cannot
+// be read again, the settings they were last read with are used.
+func getTenantEnvironment(tenant string) (environment, bool, error) {
+	environmentCache.Lock()
+	if environmentCache.config == nil {
+		config, err := loadConfig()
+		if err != nil {
+			environmentCache.Unlock()
+			return environment{}, false, err
+		}
+		environmentCache.config = config
+	}
+	config := environmentCache.config
+	cached, ok := environmentCache.envsByTenant[tenant]
+	environmentCache.Unlock()
+
+	tenantConfig, found := config.getTenantConfig(tenant)
+	if !found {
+		return environment{}, false, nil
+	}
+
+	if ok && time.Since(cached.resolvedAt) < cached.secretsTTL {
+		return cached.env, true, nil
+	}
+
+	// Reading secrets may take a while (e.g. from Secret Manager), so it is done without holding the lock, which
+	// every webhook and every other tenant needs.
+	resolvedConfig, err := tenantConfig.resolveSecrets(tenant)
+	if err != nil {
+		if ok {
+			log.Printf("\nfailed to read secrets again, using the previous ones: %v \n", err)
+			return cached.env, true, nil
+		}
+		return environment{}, false, err
+	}
+
+	if !ok || !reflect.DeepEqual(resolvedConfig, cached.resolvedConfig) {
+		env, err := resolvedConfig.newEnvironment(tenant)
+		if err != nil {
+			if ok {
+				log.Printf("\nsecrets read again are invalid, using the previous ones: %v \n", err)
+				return cached.env, true, nil
+			}
+			return environment{}, false, err
+		}
+		cached = cachedEnvironment{resolvedConfig: resolvedConfig, env: env}
+	}
+
+	// It has already been checked by resolveSecrets.
+	cached.secretsTTL, _ = getSecretsCacheTTL(tenantConfig.Secrets)
+	cached.resolvedAt = time.Now()
+
+	environmentCache.Lock()
+	// Settings read for a configuration that has since been replaced are not kept.
+	if environmentCache.config == config {
+		environmentCache.envsByTenant[tenant] = cached
+	}
+	environmentCache.Unlock()
+
+	return cached.env, true, nil
+}
+
+func getEnvironment() (environment, error) {
+	env, _, err := getTenantEnvironment("")
+	return env, err
+}
+
+// getEnvironments returns the top level settings, followed by those of every tenant. Settings that cannot be
+// loaded are logged and skipped.
+func getEnvironments() []environment {
+	var envs []environment
+
+	env, err := getEnvironment()
+	if err != nil {
+		log.Printf("\nfailed to load configuration: %v \n", err)
+	} else {
+		envs = append(envs, env)
+	}
+
+	environmentCache.Lock()
+	if environmentCache.config == nil {
+		environmentCache.Unlock()
+		return envs
+	}
+	tenants := make([]string, 0, len(environmentCache.config.Tenants))
+	for tenant := range environmentCache.config.Tenants {
+		tenants = append(tenants, tenant)
+	}
+	environmentCache.Unlock()
+	sort.Strings(tenants)
+
+	for _, tenant := range tenants {
+		env, _, err := getTenantEnvironment(tenant)
+		if err != nil {
+			log.Printf("\nfailed to load configuration of tenant %q: %v \n", tenant, err)
+			continue
+		}
+		envs = append(envs, env)
+	}
+
+	return envs
+}
+
+// getRequestEnvironment selects the settings for a request by its /hooks/{tenant} path, or its ?token= query
+// parameter, falling back to the top level settings.
+func getRequestEnvironment(r *http.Request) (environment, bool, error) {
+	if strings.HasPrefix(r.URL.Path, "/hooks/") {
+		return getTenantEnvironment(strings.Trim(strings.TrimPrefix(r.URL.Path, "/hooks/"), "/"))
+	}
+
+	if token := r.URL.Query().Get("token"); token != "" {
+		for _, env := range getEnvironments() {
+			if env.Tenant != "" && env.TenantToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(env.TenantToken)) == 1 {
+				return env, true, nil
+			}
+		}
+		return environment{}, false, nil
+	}
+
+	env, err := getEnvironment()
+	return env, err == nil, err
+}
@@ -0,0 +1,185 @@
+package function
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Event is a notification, rendered from a Clubhouse webhook (or a digest or standup), which each sink
+// (Discord included) converts to its own format.
+type Event struct {
+	// Identifies the webhook (and its actions) the event was rendered from, or the digest or standup and its period.
+	ID      string
+	Content Text
+	Items   []EventItem
+	// The webhook the event was rendered from, for sinks that post structured data. Nil for digests and standups.
+	Normalized *NormalizedEvent
+	// e.g. "blocked", for sinks that route events by kind.
+	Kinds []string
+}
+
+func getEventID(webhook ClubhouseWebhook) string {
+	parts := []string{webhook.ID}
+	for _, action := range webhook.Actions {
+		parts = append(parts, strconv.Itoa(action.ID))
+	}
+
+	return strings.Join(parts, ":")
+}
+
+type EventItem struct {
+	Title       string
+	URL         string
+	Description string
+	Colour      int
+	Fields      []EventField
+}
+
+type EventField struct {
+	Name   string
+	Value  Text
+	Inline bool
+}
+
+// Text is formatted text, as spans that each sink writes in its own markup.
+type Text []TextSpan
+
+type TextSpanKind int
+
+const (
+	TextSpanKind_Plain TextSpanKind = iota
+	TextSpanKind_Bold
+	// A literal value, e.g. a label name.
+	TextSpanKind_Code
+	TextSpanKind_Link
+	// Times are shown in each viewer's timezone by sinks that can, and as a UTC date otherwise.
+	TextSpanKind_Date
+	TextSpanKind_DateTime
+	TextSpanKind_RelativeDate
+)
+
+type TextSpan struct {
+	Kind TextSpanKind
+	Text string `json:",omitempty"`
+	URL  string `json:",omitempty"`
+	Time int64  `json:",omitempty"`
+}
+
+func plainText(text string) Text {
+	if text == "" {
+		return nil
+	}
+
+	return Text{{Text: text}}
+}
+
+func boldText(text string) Text {
+	return Text{{Kind: TextSpanKind_Bold, Text: text}}
+}
+
+func codeText(text string) Text {
+	return Text{{Kind: TextSpanKind_Code, Text: text}}
+}
+
+// linkText links text to a URL, or is plain text without one.
+func linkText(text string, url string) Text {
+	if url == "" {
+		return plainText(text)
+	}
+
+	return Text{{Kind: TextSpanKind_Link, Text: text, URL: url}}
+}
+
+func timeText(kind TextSpanKind, t time.Time) Text {
+	return Text{{Kind: kind, Time: t.Unix()}}
+}
+
+// concatText joins texts without a separator.
+func concatText(texts ...Text) Text {
+	var joined Text
+	for _, text := range texts {
+		joined = append(joined, text...)
+	}
+
+	return joined
+}
+
+func joinText(texts []Text, separator string) Text {
+	var joined Text
+	for i, text := range texts {
+		if i > 0 {
+			joined = append(joined, plainText(separator)...)
+		}
+		joined = append(joined, text...)
+	}
+
+	return joined
+}
+
+// textFormat writes each kind of span in a sink's markup.
+type textFormat struct {
+	plain func(text string) string
+	bold  func(text string) string
+	code  func(text string) string
+	link  func(text string, url string) string
+	time  func(kind TextSpanKind, t time.Time) string
+}
+
+func (t Text) format(format textFormat) string {
+	var formatted strings.Builder
+
+	for _, span := range t {
+		switch span.Kind {
+		case TextSpanKind_Bold:
+			formatted.WriteString(format.bold(span.Text))
+		case TextSpanKind_Code:
+			formatted.WriteString(format.code(span.Text))
+		case TextSpanKind_Link:
+			formatted.WriteString(format.link(span.Text, span.URL))
+		case TextSpanKind_Date, TextSpanKind_DateTime, TextSpanKind_RelativeDate:
+			formatted.WriteString(format.time(span.Kind, time.Unix(span.Time, 0).UTC()))
+		default:
+			formatted.WriteString(format.plain(span.Text))
+		}
+	}
+
+	return formatted.String()
+}
+
+func formatPlainTime(kind TextSpanKind, t time.Time) string {
+	if kind == TextSpanKind_DateTime {
+		return t.Format("Jan 2, 2006 15:04 UTC")
+	}
+
+	return t.Format("Jan 2, 2006")
+}
+
+// String returns the text without markup, with links followed by their URL.
+func (t Text) String() string {
+	return t.format(textFormat{
+		plain: func(text string) string { return text },
+		bold:  func(text string) string { return text },
+		code:  func(text string) string { return text },
+		link:  func(text string, url string) string { return text + " (" + url + ")" },
+		time:  formatPlainTime,
+	})
+}
+
+// UnmarshalJSON also accepts a string, which is how text was stored (e.g. in email batches) before it had spans.
+func (t *Text) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*t = plainText(text)
+		return nil
+	}
+
+	var spans []TextSpan
+	if err := json.Unmarshal(data, &spans); err != nil {
+		return err
+	}
+	*t = spans
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package function
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTextFormats(t *testing.T) {
+	deadline := time.Date(2021, time.March, 4, 5, 6, 0, 0, time.UTC)
+	text := concatText(
+		boldText("Due"),
+		plainText(" <soon> "),
+		linkText("Story & co", "https://app.clubhouse.io/workspace/story/1"),
+		plainText(" "),
+		codeText("bug"),
+		plainText(" "),
+		DeadlineFormat{TimestampMarkup: true}.Format(&deadline),
+	)
+
+	tests := []struct {
+		name   string
+		format func(Text) string
+		want   string
+	}{
+		{
+			"discord",
+			toDiscordMarkdown,
+			"**Due** <soon> [Story & co](https://app.clubhouse.io/workspace/story/1) `bug` <t:1614834360:D> (<t:1614834360:R>)",
+		},
+		{
+			"slack",
+			toSlackMarkdown,
+			"*Due* &lt;soon&gt; <https://app.clubhouse.io/workspace/story/1|Story &amp; co> `bug` <!date^1614834360^{date_short_pretty}|Mar 4, 2021> (<!date^1614834360^{date_short_pretty}|Mar 4, 2021>)",
+		},
+		{
+			"teams",
+			toTeamsMarkdown,
+			"**Due** <soon> [Story & co](https://app.clubhouse.io/workspace/story/1) bug {{DATE(2021-03-04T05:06:00Z, SHORT)}} ({{DATE(2021-03-04T05:06:00Z, SHORT)}})",
+		},
+		{
+			"html",
+			toHTML,
+			`<strong>Due</strong> &lt;soon&gt; <a href="https://app.clubhouse.io/workspace/story/1">Story &amp; co</a> <code>bug</code> Mar 4, 2021 (Mar 4, 2021)`,
+		},
+		{
+			"plain",
+			Text.String,
+			"Due <soon> Story & co (https://app.clubhouse.io/workspace/story/1) bug Mar 4, 2021 (Mar 4, 2021)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.format(text); got != test.want {
+				t.Errorf("format() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDeadlineFormatWithoutTimestamps(t *testing.T) {
+	deadline := time.Date(2021, time.March, 4, 5, 6, 0, 0, time.UTC)
+	format := DeadlineFormat{Location: time.UTC, Layout: defaultDeadlineLayout}
+
+	if got := format.Format(&deadline); !reflect.DeepEqual(got, plainText("Thu, Mar 4 2021")) {
+		t.Errorf("Format() = %#v, want plain text", got)
+	}
+	if got := format.Format(nil).String(); got != "No Date" {
+		t.Errorf("Format(nil) = %q, want No Date", got)
+	}
+}
+
+func TestLabelChips(t *testing.T) {
+	if got := toDiscordMarkdown(getLabelChip("bug", "#e74c3c")); got != "🟥 `bug`" {
+		t.Errorf("getLabelChip() = %q, want a swatch and the name as code", got)
+	}
+	if got := toSlackMarkdown(getLabelChip("<bug>", "")); got != "`&lt;bug&gt;`" {
+		t.Errorf("getLabelChip() = %q, want the escaped name as code", got)
+	}
+}
+
+func TestTextUnmarshalJSON(t *testing.T) {
+	var field EventField
+	if err := json.Unmarshal([]byte(`{"Name":"State","Value":"Done"}`), &field); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(field.Value, plainText("Done")) {
+		t.Errorf("Value = %#v, want plain text from a string", field.Value)
+	}
+
+	text := concatText(boldText("Due"), plainText(" "), timeText(TextSpanKind_Date, time.Unix(1614834360, 0)))
+	data, err := json.Marshal(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Text
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, text) {
+		t.Errorf("Unmarshal() = %#v, want %#v", got, text)
+	}
+}
+
+func TestGetEventID(t *testing.T) {
+	webhook := ClubhouseWebhook{ID: "595285dc-9c43-4b9c-a1e6-0cd9aff5b084", Actions: []ClubhouseAction{{ID: 1}, {ID: 2}}}
+	if got := getEventID(webhook); got != "595285dc-9c43-4b9c-a1e6-0cd9aff5b084:1:2" {
+		t.Errorf("getEventID() = %q, want the webhook and action IDs", got)
+	}
+}
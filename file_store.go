@@ -0,0 +1,63 @@
+package function
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var fileStoreMutex sync.Mutex
+
+// lockDir takes a lock file in the directory, so that only one instance modifies it at a time.
+func lockDir(dir string) (func(), error) {
+	fileStoreMutex.Lock()
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		fileStoreMutex.Unlock()
+		return nil, err
+	}
+
+	lockPath := filepath.Join(dir, ".lock")
+	deadline := time.Now().Add(10 * time.Second)
+
+	for {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			lockFile.Close()
+			return func() {
+				os.Remove(lockPath)
+				fileStoreMutex.Unlock()
+			}, nil
+		}
+
+		if !os.IsExist(err) {
+			fileStoreMutex.Unlock()
+			return nil, err
+		}
+
+		// Locks left behind by instances that died are removed.
+		if info, err := os.Stat(lockPath); err == nil && time.Since(info.ModTime()) > 30*time.Second {
+			os.Remove(lockPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			fileStoreMutex.Unlock()
+			return nil, fmt.Errorf("timed out waiting for lock: %s", lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// writeFileAtomic writes to a temporary file first, so that readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
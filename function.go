@@ -2,18 +2,14 @@ package function
 
 import (
 	"bytes"
-	"crypto/hmac"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/url"
-	"os"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 )
 
@@ -30,38 +26,54 @@ type ClubhouseWebhook struct {
 }
 
 type ClubhouseAction struct {
-	Action          string           `json:"action"`
-	AppURL          string           `json:"app_url"`
-	AuthorID        string           `json:"author_id"`
-	Changes         ClubhouseChanges `json:"changes"`
-	Complete        bool             `json:"complete,omitempty"`
-	Description     string           `json:"description"`
-	EntityType      string           `json:"entity_type"`
-	EpicID          int              `json:"epic_id"`
-	Estimate        int              `json:"estimate,omitempty"`
-	FollowerIds     []string         `json:"follower_ids"`
-	ID              int              `json:"id"`
-	IterationID     int              `json:"iteration_id"`
-	MilestoneID     int              `json:"milestone_id"`
-	Name            string           `json:"name"`
-	OwnerIds        []string         `json:"owner_ids"`
-	Position        int64            `json:"position"`
-	ProjectID       int              `json:"project_id"`
-	RequestedByID   string           `json:"requested_by_id"`
-	StoryType       string           `json:"story_type"`
-	TaskIds         []int            `json:"task_ids,omitempty"`
-	Town            *string          `json:"town,omitempty"`
-	Text            string           `json:"text"`
-	URL             string           `json:"url"`
-	WorkflowStateID int              `json:"workflow_state_id"`
+	Action           string                      `json:"action"`
+	AppURL           string                      `json:"app_url"`
+	AuthorID         string                      `json:"author_id"`
+	BranchName       string                      `json:"branch_name,omitempty"`
+	Changes          ClubhouseChanges            `json:"changes"`
+	Color            string                      `json:"color,omitempty"`
+	Complete         bool                        `json:"complete,omitempty"`
+	CustomFields     []ClubhouseCustomFieldValue `json:"custom_fields,omitempty"`
+	Deadline         *time.Time                  `json:"deadline,omitempty"`
+	Description      string                      `json:"description"`
+	EntityType       string                      `json:"entity_type"`
+	EpicID           int                         `json:"epic_id"`
+	Estimate         int                         `json:"estimate,omitempty"`
+	FollowerIds      []string                    `json:"follower_ids"`
+	Hash             string                      `json:"hash,omitempty"`
+	ID               int                         `json:"id"`
+	IterationID      int                         `json:"iteration_id"`
+	LabelIds         []int                       `json:"label_ids,omitempty"`
+	Message          string                      `json:"message,omitempty"`
+	MilestoneID      int                         `json:"milestone_id"`
+	Name             string                      `json:"name"`
+	Number           int                         `json:"number,omitempty"`
+	ObjectID         int                         `json:"object_id,omitempty"`
+	OwnerIds         []string                    `json:"owner_ids"`
+	Position         int64                       `json:"position"`
+	ProjectID        int                         `json:"project_id"`
+	RepositoryID     int                         `json:"repository_id,omitempty"`
+	RequestedByID    string                      `json:"requested_by_id"`
+	StoryType        string                      `json:"story_type"`
+	SubjectID        int                         `json:"subject_id,omitempty"`
+	TargetBranchName string                      `json:"target_branch_name,omitempty"`
+	TaskIds          []int                       `json:"task_ids,omitempty"`
+	Title            string                      `json:"title,omitempty"`
+	Town             *string                     `json:"town,omitempty"`
+	Text             string                      `json:"text"`
+	URL              string                      `json:"url"`
+	Verb             string                      `json:"verb,omitempty"`
+	WorkflowStateID  int                         `json:"workflow_state_id"`
 }
 
 type ClubhouseReference struct {
 	AppURL     string `json:"app_url"`
+	Color      string `json:"color,omitempty"`
 	EntityType string `json:"entity_type"`
 	ID         int    `json:"id"`
 	Name       string `json:"name"`
 	Type       string `json:"type"`
+	URL        string `json:"url,omitempty"`
 }
 
 type ClubhouseChanges struct {
@@ -69,14 +81,34 @@ type ClubhouseChanges struct {
 		New bool `json:"new"`
 		Old bool `json:"old"`
 	} `json:"archived,omitempty"`
+	Blocked *struct {
+		New bool `json:"new"`
+		Old bool `json:"old"`
+	} `json:"blocked,omitempty"`
 	Blocker *struct {
 		New bool `json:"new"`
 		Old bool `json:"old"`
 	} `json:"blocker,omitempty"`
+	BranchIds *struct {
+		Adds    []int `json:"adds"`
+		Removes []int `json:"removes"`
+	} `json:"branch_ids,omitempty"`
+	Closed *struct {
+		New bool `json:"new"`
+		Old bool `json:"old"`
+	} `json:"closed,omitempty"`
+	Color *struct {
+		New string `json:"new"`
+		Old string `json:"old"`
+	} `json:"color,omitempty"`
 	CommentIds *struct {
 		Adds    []int `json:"adds"`
 		Removes []int `json:"removes"`
 	} `json:"comment_ids,omitempty"`
+	CommitIds *struct {
+		Adds    []int `json:"adds"`
+		Removes []int `json:"removes"`
+	} `json:"commit_ids,omitempty"`
 	Completed *struct {
 		New bool `json:"new"`
 		Old bool `json:"old"`
@@ -84,10 +116,18 @@ type ClubhouseChanges struct {
 	CompletedAt *struct {
 		New time.Time `json:"new"`
 	} `json:"completed_at,omitempty"`
+	CustomFields *struct {
+		Adds    []ClubhouseCustomFieldValue `json:"adds"`
+		Removes []ClubhouseCustomFieldValue `json:"removes"`
+	} `json:"custom_fields,omitempty"`
 	Deadline *struct {
 		New *time.Time `json:"new,omitempty"`
 		Old *time.Time `json:"old,omitempty"`
 	} `json:"deadline,omitempty"`
+	Description *struct {
+		New string `json:"new"`
+		Old string `json:"old"`
+	} `json:"description,omitempty"`
 	EpicID *struct {
 		New *int `json:"new,omitempty"`
 		Old *int `json:"old,omitempty"`
@@ -96,9 +136,22 @@ type ClubhouseChanges struct {
 		New *int `json:"new,omitempty"`
 		Old *int `json:"old,omitempty"`
 	} `json:"estimate,omitempty"`
+	ExternalLinks *struct {
+		Adds    []string `json:"adds"`
+		Removes []string `json:"removes"`
+	} `json:"external_links,omitempty"`
+	FileIds *struct {
+		Adds    []int `json:"adds"`
+		Removes []int `json:"removes"`
+	} `json:"file_ids,omitempty"`
 	FollowerIds *struct {
-		Adds []string `json:"adds"`
+		Adds    []string `json:"adds"`
+		Removes []string `json:"removes"`
 	} `json:"follower_ids,omitempty"`
+	GroupID *struct {
+		New *string `json:"new,omitempty"`
+		Old *string `json:"old,omitempty"`
+	} `json:"group_id,omitempty"`
 	IterationID *struct {
 		New *int `json:"new,omitempty"`
 		Old *int `json:"old,omitempty"`
@@ -107,6 +160,18 @@ type ClubhouseChanges struct {
 		Adds    []int `json:"adds"`
 		Removes []int `json:"removes"`
 	} `json:"label_ids,omitempty"`
+	LinkedFileIds *struct {
+		Adds    []int `json:"adds"`
+		Removes []int `json:"removes"`
+	} `json:"linked_file_ids,omitempty"`
+	Merged *struct {
+		New bool `json:"new"`
+		Old bool `json:"old"`
+	} `json:"merged,omitempty"`
+	Name *struct {
+		New string `json:"new"`
+		Old string `json:"old"`
+	} `json:"name,omitempty"`
 	OwnerIds *struct {
 		Adds    []string `json:"adds"`
 		Removes []string `json:"removes"`
@@ -119,6 +184,14 @@ type ClubhouseChanges struct {
 		New int `json:"new"`
 		Old int `json:"old"`
 	} `json:"project_id,omitempty"`
+	PullRequestIds *struct {
+		Adds    []int `json:"adds"`
+		Removes []int `json:"removes"`
+	} `json:"pull_request_ids,omitempty"`
+	RequestedByID *struct {
+		New string `json:"new"`
+		Old string `json:"old"`
+	} `json:"requested_by_id,omitempty"`
 	Started *struct {
 		New bool `json:"new"`
 		Old bool `json:"old"`
@@ -126,10 +199,18 @@ type ClubhouseChanges struct {
 	StartedAt *struct {
 		New time.Time `json:"new"`
 	} `json:"started_at,omitempty"`
+	StoryLinkIds *struct {
+		Adds    []int `json:"adds"`
+		Removes []int `json:"removes"`
+	} `json:"story_link_ids,omitempty"`
 	StoryType *struct {
 		New string `json:"new"`
 		Old string `json:"old"`
 	} `json:"story_type,omitempty"`
+	TaskIds *struct {
+		Adds    []int `json:"adds"`
+		Removes []int `json:"removes"`
+	} `json:"task_ids,omitempty"`
 	Text *struct {
 		New string `json:"new"`
 		Old string `json:"old"`
@@ -138,6 +219,23 @@ type ClubhouseChanges struct {
 		New int `json:"new"`
 		Old int `json:"old"`
 	} `json:"workflow_state_id,omitempty"`
+
+	// All changes, decoded generically, and the keys that are not modelled above.
+	Generic map[string]ClubhouseGenericChange `json:"-"`
+	Unknown map[string]json.RawMessage        `json:"-"`
+}
+
+type ClubhouseGenericChange struct {
+	Adds    []json.RawMessage `json:"adds,omitempty"`
+	New     json.RawMessage   `json:"new,omitempty"`
+	Old     json.RawMessage   `json:"old,omitempty"`
+	Removes []json.RawMessage `json:"removes,omitempty"`
+}
+
+type ClubhouseCustomFieldValue struct {
+	FieldID string `json:"field_id"`
+	Value   string `json:"value"`
+	ValueID string `json:"value_id"`
 }
 
 type OverallAction int
@@ -155,7 +253,7 @@ type DiscordWebhook struct {
 
 type Embed struct {
 	Title       string  `json:"title"`
-	URL         string  `json:"url"`
+	URL         string  `json:"url,omitempty"`
 	Description string  `json:"description"`
 	Color       int     `json:"color"`
 	Fields      []Field `json:"fields,omitempty"`
@@ -167,30 +265,105 @@ type Field struct {
 	Inline bool   `json:"inline"`
 }
 
-func toDiscord(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook) (*DiscordWebhook, error) {
+type DiscordOptions struct {
+	// Skip VCS (branch, commit, pull request) embeds when they only mirror a story state change,
+	// and post the story state change instead.
+	SuppressVCSStateChanges bool
+	Deadlines               DeadlineFormat
+	// Post a best-effort embed for events that are not otherwise handled.
+	RenderUnknownEvents bool
+	// Keyed by lower case workflow state name or type.
+	WorkflowStateStyles map[string]WorkflowStateStyle
+	// Show stories being moved up or down the backlog. Off by default, as every drag is a change.
+	ShowPositionChanges bool
+	// Renders embed titles instead of "{Actor} {verb} {entity type}: {name}".
+	TitleTemplate *template.Template
+}
+
+func toEvent(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook, options DiscordOptions) (*Event, error) {
+	if !options.ShowPositionChanges && isPositionChangeOnly(webhook) {
+		return nil, nil
+	}
+
+	if !options.RenderUnknownEvents {
+		return toKnownEvent(clubhouseApiClient, webhook, options)
+	}
+
+	if len(webhook.Actions) > 1 && !hasLinkedActions(webhook) {
+		return toGenericEvent(clubhouseApiClient, webhook, options)
+	}
+
+	event, err := toKnownEvent(clubhouseApiClient, webhook, options)
+	if err != nil || event != nil {
+		return event, err
+	}
+
+	return toGenericEvent(clubhouseApiClient, webhook, options)
+}
+
+// isPositionChangeOnly reports whether a webhook only moves stories up or down the backlog.
+func isPositionChangeOnly(webhook ClubhouseWebhook) bool {
+	for _, action := range webhook.Actions {
+		if action.Action != "update" || action.Changes.Position == nil || len(action.Changes.Generic) != 1 {
+			return false
+		}
+	}
+
+	return len(webhook.Actions) > 0
+}
+
+func toKnownEvent(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook, options DiscordOptions) (*Event, error) {
 	var webhookTitle string
 	var webhookURL string
-	var fields []Field
+	var fields []EventField
 	var colour int
 
 	firstAction := webhook.Actions[0]
 
-	// actionsByID := getActionsByID(webhook)
 	referencesByTypeID := getReferencesByTypeID(webhook)
 
+	if vcsActions, storyActions := splitVCSActions(webhook); len(vcsActions) > 0 {
+		storyAction, ok := findStoryStateChange(storyActions)
+		if !options.SuppressVCSStateChanges || !ok {
+			return toVCSEvent(clubhouseApiClient, referencesByTypeID, webhook, vcsActions[0], storyActions, options)
+		}
+
+		firstAction = storyAction
+	}
+
+	if linkAction, ok := findAction(webhook, "story-link"); ok {
+		return toStoryLinkEvent(referencesByTypeID, webhook, linkAction)
+	}
+
+	if firstAction.EntityType == "label" {
+		return toLabelEvent(clubhouseApiClient, webhook, firstAction, options)
+	}
+
 	var err error
+	verb := firstAction.Action + "d"
 
 	switch firstAction.Action {
 	case "create":
 		colour = 5424154
-		fields = getActionFields(referencesByTypeID, firstAction)
+		fields, err = getActionFields(clubhouseApiClient, referencesByTypeID, firstAction, options)
+		if err != nil {
+			return nil, err
+		}
 
 		if len(fields) == 0 {
 			return nil, nil
 		}
 	case "update":
 		colour = 16440084
-		fields, err = getChangesFields(clubhouseApiClient, referencesByTypeID, firstAction.Changes)
+		if firstAction.Changes.WorkflowStateID != nil {
+			if stateColour, ok := getWorkflowStateColour(clubhouseApiClient, referencesByTypeID, firstAction.Changes.WorkflowStateID.New, options); ok {
+				colour = stateColour
+			}
+		}
+		if isNewBlocker(firstAction.Changes) {
+			colour = 16065069
+		}
+		fields, err = getChangesFields(clubhouseApiClient, referencesByTypeID, firstAction.Changes, options)
 		if err != nil {
 			return nil, err
 		}
@@ -198,6 +371,18 @@ func toDiscord(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook)
 		if len(fields) == 0 {
 			return nil, nil
 		}
+
+		if transitionVerb := getTransitionVerb(firstAction.Changes); transitionVerb != "" {
+			verb = transitionVerb
+		}
+
+		cycleTimeField, err := getCycleTimeField(clubhouseApiClient, firstAction)
+		if err != nil {
+			return nil, err
+		}
+		if cycleTimeField != nil {
+			fields = append(fields, *cycleTimeField)
+		}
 	case "delete":
 		colour = 16065069
 	default:
@@ -205,26 +390,16 @@ func toDiscord(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook)
 	}
 
 	if firstAction.Action != "" && firstAction.EntityType != "" && firstAction.Name != "" {
-		if webhook.MemberID != "" {
-			member, err := clubhouseApiClient.GetMember(webhook.MemberID)
-			if err != nil {
-				return nil, err
-			}
-
-			webhookTitle = fmt.Sprintf(
-				"%s %sd %s: %s",
-				strings.Title(member.Profile.Name),
-				firstAction.Action,
-				firstAction.EntityType,
-				firstAction.Name,
-			)
-		} else {
-			webhookTitle = fmt.Sprintf(
-				"%sd %s: %s",
-				strings.Title(firstAction.Action),
-				firstAction.EntityType,
-				firstAction.Name,
-			)
+		webhookTitle, err = getWebhookTitle(
+			clubhouseApiClient,
+			options,
+			webhook.MemberID,
+			verb,
+			firstAction.EntityType,
+			firstAction.Name,
+		)
+		if err != nil {
+			return nil, err
 		}
 	}
 	if firstAction.AppURL != "" {
@@ -235,34 +410,95 @@ func toDiscord(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook)
 		return nil, nil
 	}
 
-	return &DiscordWebhook{
-		Embeds: []Embed{
+	return &Event{
+		Items: []EventItem{
 			{
 				Title:  webhookTitle,
 				URL:    webhookURL,
-				Color:  colour,
+				Colour: colour,
 				Fields: fields,
 			},
 		},
 	}, nil
 }
 
-func F(w http.ResponseWriter, r *http.Request) {
-	discordWebhookURL := os.Getenv("DISCORD_WEBHOOK_URL")
-	if discordWebhookURL == "" {
-		log.Fatalln("`DISCORD_WEBHOOK_URL` is not set in the environment")
+// hasLinkedActions reports whether a webhook has an action that Clubhouse sends together with
+// the story actions it affects.
+func hasLinkedActions(webhook ClubhouseWebhook) bool {
+	for _, action := range webhook.Actions {
+		if isVCSEntityType(action.EntityType) || action.EntityType == "story-link" {
+			return true
+		}
 	}
 
-	if _, err := url.Parse(discordWebhookURL); err != nil {
-		log.Fatalln(err)
+	return false
+}
+
+// titleTemplateData is given to TITLE_TEMPLATE.
+type titleTemplateData struct {
+	Actor      string
+	Verb       string
+	EntityType string
+	Name       string
+}
+
+// parseTitleTemplate parses a title template, and renders it once so that e.g. unknown fields are reported
+// with the configuration rather than on the first webhook.
+func parseTitleTemplate(text string) (*template.Template, error) {
+	titleTemplate, err := template.New("title").Parse(text)
+	if err != nil {
+		return nil, err
 	}
 
-	clubhouseApiToken := os.Getenv("CLUBHOUSE_API_TOKEN")
-	if clubhouseApiToken == "" {
-		log.Fatalln("`CLUBHOUSE_API_TOKEN` is not set in the environment")
+	sample := titleTemplateData{Actor: "Jane Doe", Verb: "updated", EntityType: "story", Name: "Fix the login page"}
+	if err := titleTemplate.Execute(ioutil.Discard, sample); err != nil {
+		return nil, err
 	}
 
-	clubhouseApiClient := &ClubhouseApiClient{ApiToken: clubhouseApiToken}
+	return titleTemplate, nil
+}
+
+func getWebhookTitle(clubhouseApiClient *ClubhouseApiClient, options DiscordOptions, memberID string, verb string, entityType string, name string) (string, error) {
+	var actor string
+	if memberID != "" {
+		member, err := clubhouseApiClient.GetMember(memberID)
+		if err != nil {
+			return "", err
+		}
+		actor = strings.Title(member.Profile.Name)
+	}
+
+	if options.TitleTemplate != nil {
+		var title bytes.Buffer
+		data := titleTemplateData{Actor: actor, Verb: verb, EntityType: entityType, Name: name}
+		if err := options.TitleTemplate.Execute(&title, data); err != nil {
+			return "", err
+		}
+		return title.String(), nil
+	}
+
+	if actor == "" {
+		return fmt.Sprintf("%s %s: %s", strings.Title(verb), entityType, name), nil
+	}
+
+	return fmt.Sprintf("%s %s %s: %s", actor, verb, entityType, name), nil
+}
+
+func F(w http.ResponseWriter, r *http.Request) {
+	env, ok, err := getRequestEnvironment(r)
+	if err != nil {
+		log.Printf("\nfailed to load configuration: %v \n", err)
+		http.Error(w, "failed to load configuration", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		log.Printf("\nunknown tenant: %s \n", r.URL.Path)
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte("unknown tenant"))
+		return
+	}
+
+	clubhouseApiClient := env.newClubhouseApiClient()
 
 	if contentType := r.Header.Get("Content-Type"); r.Method != "POST" || contentType != "application/json" {
 		log.Printf("\ninvalid method / content-type: %s / %s \n", r.Method, contentType)
@@ -273,41 +509,40 @@ func F(w http.ResponseWriter, r *http.Request) {
 
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		log.Fatalln(err)
+		log.Println("failed to read request body:", err)
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
 	}
 
-	clubhouseWebhookSecret := os.Getenv("CLUBHOUSE_WEBHOOK_SECRET")
-
 	if clubhouseSignature := strings.TrimSpace(r.Header.Get("Clubhouse-Signature")); clubhouseSignature != "" {
-		if clubhouseWebhookSecret == "" {
-			log.Fatalln("received webhook with signature, but `CLUBHOUSE_WEBHOOK_SECRET` was not set in the environment")
-		}
-
-		mac := hmac.New(sha256.New, []byte(strings.TrimSpace(clubhouseWebhookSecret)))
-		_, err = mac.Write(data)
-		if err != nil {
-			log.Fatalln(err)
-		}
-		expectedMAC := mac.Sum(nil)
-
-		clubhouseHexSignature, err := hex.DecodeString(clubhouseSignature)
-		if err != nil {
-			log.Fatalln(err)
+		// The signature cannot be verified, so the webhook is not trusted. It is a server error, so that
+		// Clubhouse retries the webhook once the secret is set.
+		if len(env.ClubhouseWebhookSecrets) == 0 {
+			log.Println("received webhook with signature, but `CLUBHOUSE_WEBHOOK_SECRET` was not set in the environment")
+			http.Error(w, "webhook secret is not configured", http.StatusInternalServerError)
+			return
 		}
 
-		if !hmac.Equal(clubhouseHexSignature, expectedMAC) {
-			log.Printf("\nsignature does not match: %s (got) != %s (want) \n", hex.EncodeToString(clubhouseHexSignature), hex.EncodeToString(expectedMAC))
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write([]byte("invalid request"))
+		if !verifyClubhouseSignature(env.ClubhouseWebhookSecrets, clubhouseSignature, data) {
+			log.Printf("\nsignature does not match: %q \n", clubhouseSignature)
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("invalid signature"))
 			return
 		}
+	} else if env.ClubhouseWebhookStrict {
+		log.Println("received webhook without signature, but `CLUBHOUSE_WEBHOOK_SECRET` is set in the environment")
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte("missing signature"))
+		return
 	}
 
 	var webhook ClubhouseWebhook
 	err = json.Unmarshal(data, &webhook)
 	if err != nil {
 		log.Printf("\nraw data received: %q \n", data)
-		log.Fatalln(err)
+		log.Println("failed to parse webhook:", err)
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
 	}
 
 	if webhook.Version != "v1" {
@@ -317,55 +552,174 @@ func F(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if totalActions := len(webhook.Actions); totalActions != 1 {
-		log.Printf("\nunhandled raw data received: %q \n", data)
-		w.WriteHeader(http.StatusOK)
-		return
+	// The webhook ID is recorded before it is handled, so that a retry sent while it is being handled is rejected.
+	// If it fails, the ID is forgotten again, so that Clubhouse's retry is accepted.
+	forgetWebhookID := func() {}
+
+	if env.ReplayWindow > 0 {
+		nonceStore := &FileNonceStore{Dir: env.ReplayStoreDir}
+		reason, err := checkReplay(nonceStore, webhook, env.ReplayWindow, time.Now())
+		if err != nil {
+			log.Println("failed to check for replays:", err)
+			http.Error(w, "failed to check for replays", http.StatusInternalServerError)
+			return
+		}
+
+		switch reason {
+		case ReplayReason_Stale, ReplayReason_Future:
+			log.Printf("\nwebhook %s changed at %s, outside of the replay window \n", webhook.ID, webhook.ChangedAt)
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte("webhook is outside of the replay window"))
+			return
+		case ReplayReason_Duplicate:
+			log.Printf("\nwebhook %s has already been received \n", webhook.ID)
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte("webhook has already been received"))
+			return
+		}
+
+		forgetWebhookID = func() {
+			if err := nonceStore.Forget(webhook.ID); err != nil {
+				log.Printf("\nfailed to forget webhook %s, its retries will be rejected: %s \n", webhook.ID, err)
+			}
+		}
+	}
+
+	if env.CoalesceWindow > 0 {
+		coalesceStore := &FileCoalesceStore{Dir: env.CoalesceStoreDir}
+		defer flushCoalescedWebhooks(env, clubhouseApiClient, coalesceStore)
+
+		if isCoalescable(webhook) {
+			err = coalesceStore.Add(getCoalesceKey(webhook), data, time.Now())
+			if err != nil {
+				log.Println("failed to buffer webhook:", err)
+				forgetWebhookID()
+				http.Error(w, "failed to buffer webhook", http.StatusInternalServerError)
+				return
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
 	}
 
-	discordWebhook, err := toDiscord(clubhouseApiClient, webhook)
+	event, sinkResults, err := forwardWebhook(env, clubhouseApiClient, webhook, data)
 	if err != nil {
 		log.Printf("\nraw data received: %q \n", data)
-		log.Fatalln(err)
+		log.Println("failed to forward webhook:", err)
+		forgetWebhookID()
+		http.Error(w, "failed to forward webhook", http.StatusInternalServerError)
+		return
 	}
-	if discordWebhook == nil {
-		log.Printf("\nunhandled raw data received: %q \n", data)
+	if event == nil {
 		w.WriteHeader(http.StatusOK)
 		return
 	}
 
-	payload, err := json.Marshal(discordWebhook)
-	if err != nil {
-		log.Fatalln(err)
+	statusCode := getDeliveryStatusCode(sinkResults)
+	if statusCode >= 500 {
+		forgetWebhookID()
 	}
 
-	res, err := http.Post(discordWebhookURL, "application/json", bytes.NewBuffer(payload))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	discordWebhook := toDiscordWebhook(*event)
+	err = json.NewEncoder(w).Encode(struct {
+		DiscordWebhook
+		Sinks []SinkResult `json:"sinks"`
+	}{discordWebhook, sinkResults})
 	if err != nil {
-		log.Fatalln(err)
+		log.Println("failed to write response:", err)
 	}
-	defer res.Body.Close()
+}
 
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		log.Println("payload", string(payload))
-		log.Fatalln("unexpected status code", res.StatusCode)
+// forwardWebhook renders a Clubhouse webhook as an event and sends it to Discord and the other sinks, or stores it
+// for a digest. It returns nil if the webhook is not posted, and the result of each sink otherwise. Sinks failing
+// is not an error.
+func forwardWebhook(
+	env environment,
+	clubhouseApiClient *ClubhouseApiClient,
+	webhook ClubhouseWebhook,
+	data []byte,
+) (*Event, []SinkResult, error) {
+	// VCS and story link events arrive together with the story actions they are linked to.
+	if len(webhook.Actions) == 0 || (len(webhook.Actions) > 1 && !hasLinkedActions(webhook) && !env.DiscordOptions.RenderUnknownEvents) {
+		log.Printf("\nunhandled raw data received: %q \n", data)
+		return nil, nil, nil
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	err = json.NewEncoder(w).Encode(discordWebhook)
+	var customFieldRoute *CustomFieldRule
+	if len(env.CustomFieldFilters) > 0 || len(env.CustomFieldRoutes) > 0 {
+		customFieldValues, err := getWebhookCustomFieldValues(clubhouseApiClient, webhook)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if _, ok := matchCustomFieldRules(customFieldValues, env.CustomFieldFilters); len(env.CustomFieldFilters) > 0 && !ok {
+			log.Printf("\nfiltered raw data received: %q \n", data)
+			return nil, nil, nil
+		}
+
+		if route, ok := matchCustomFieldRules(customFieldValues, env.CustomFieldRoutes); ok {
+			customFieldRoute = &route
+		}
+	}
+
+	discordWebhookURL := env.DiscordWebhookURL
+	if customFieldRoute != nil {
+		discordWebhookURL = customFieldRoute.WebhookURL
+	} else if env.DiscordEscalationWebhookURL != "" && isEscalation(webhook) {
+		discordWebhookURL = env.DiscordEscalationWebhookURL
+	}
+
+	sinks := env.Sinks
+	if digestChannel, ok := findDigestChannel(env.DigestChannels, discordWebhookURL); ok {
+		digestEntries, err := getDigestEntries(clubhouseApiClient, webhook, time.Now())
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(digestEntries) == 0 {
+			log.Printf("\nunhandled raw data received: %q \n", data)
+		} else if err := (&FileDigestStore{Dir: env.DigestStoreDir}).Add(digestChannel, digestEntries); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		discordSink := ConfiguredSink{
+			Sink:   &DiscordSink{SinkName: "discord", WebhookURL: discordWebhookURL},
+			Policy: env.DiscordPolicy,
+		}
+		sinks = append([]ConfiguredSink{discordSink}, sinks...)
+	}
+
+	if len(sinks) == 0 {
+		return nil, nil, nil
+	}
+
+	event, err := toEvent(clubhouseApiClient, webhook, env.DiscordOptions)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, nil, err
+	}
+	if event == nil {
+		log.Printf("\nunhandled raw data received: %q \n", data)
+		return nil, nil, nil
 	}
-}
 
-func getActionsByID(webhook ClubhouseWebhook) map[string]ClubhouseAction {
-	actionsByID := make(map[string]ClubhouseAction)
+	event.ID = getEventID(webhook)
+	event.Kinds = getEventKinds(webhook)
+	if needsNormalizedEvent(sinks) {
+		summaries := make([]string, len(event.Items))
+		for i, item := range event.Items {
+			summaries[i] = item.Title
+		}
 
-	for _, action := range webhook.Actions {
-		actionsByID[strconv.Itoa(action.ID)] = action
+		// Without it, only the sinks that need it fail.
+		event.Normalized, err = newNormalizedEvent(clubhouseApiClient, webhook, summaries)
+		if err != nil {
+			log.Println("failed to normalize webhook:", err)
+		}
 	}
 
-	return actionsByID
+	return event, deliverToSinks(sinks, *event), nil
 }
 
 func getReferencesByTypeID(webhook ClubhouseWebhook) map[string]ClubhouseReference {
@@ -379,124 +733,167 @@ func getReferencesByTypeID(webhook ClubhouseWebhook) map[string]ClubhouseReferen
 	return referencesByTypeID
 }
 
-func getActionFields(referencesByTypeID map[string]ClubhouseReference, action ClubhouseAction) []Field {
-	var fields []Field
+func getActionFields(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	action ClubhouseAction,
+	options DiscordOptions,
+) ([]EventField, error) {
+	var fields []EventField
 
 	if action.StoryType != "" {
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:   "Type",
-			Value:  action.StoryType,
+			Value:  plainText(action.StoryType),
 			Inline: true,
 		})
 	}
 
 	if action.ProjectID > 0 {
-		projectTypeID := fmt.Sprintf("%s:%d", "project", action.ProjectID)
-		project := referencesByTypeID[projectTypeID]
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:   "Project",
-			Value:  project.Name,
+			Value:  plainText(resolveReferenceName(clubhouseApiClient, referencesByTypeID, "project", action.ProjectID)),
 			Inline: true,
 		})
 	}
 
 	if action.MilestoneID > 0 {
-		milestoneTypeID := fmt.Sprintf("%s:%d", "milestone", action.MilestoneID)
-		milestone := referencesByTypeID[milestoneTypeID]
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:   "Milestone",
-			Value:  milestone.Name,
+			Value:  plainText(resolveReferenceName(clubhouseApiClient, referencesByTypeID, "milestone", action.MilestoneID)),
 			Inline: true,
 		})
 	}
 
 	if action.WorkflowStateID > 0 {
-		workflowStateTypeID := fmt.Sprintf("%s:%d", "workflow-state", action.WorkflowStateID)
-		workflowState := referencesByTypeID[workflowStateTypeID]
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:   "State",
-			Value:  workflowState.Name,
+			Value:  plainText(getWorkflowStateValue(clubhouseApiClient, referencesByTypeID, action.WorkflowStateID, options)),
 			Inline: true,
 		})
 	}
 
 	if action.EpicID > 0 {
-		epicTypeID := fmt.Sprintf("%s:%d", "epic", action.EpicID)
-		epic := referencesByTypeID[epicTypeID]
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:   "Epic",
-			Value:  epic.Name,
+			Value:  plainText(resolveReferenceName(clubhouseApiClient, referencesByTypeID, "epic", action.EpicID)),
 			Inline: true,
 		})
 	}
 
 	if action.IterationID > 0 {
-		iterationTypeID := fmt.Sprintf("%s:%d", "iteration", action.IterationID)
-		iteration := referencesByTypeID[iterationTypeID]
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:   "Iteration",
-			Value:  iteration.Name,
+			Value:  plainText(resolveReferenceName(clubhouseApiClient, referencesByTypeID, "iteration", action.IterationID)),
+			Inline: true,
+		})
+	}
+
+	if action.Deadline != nil {
+		fields = append(fields, EventField{
+			Name:   "Deadline",
+			Value:  options.Deadlines.Format(action.Deadline),
 			Inline: true,
 		})
 	}
 
 	if action.Estimate > 0 {
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:   "Estimate",
-			Value:  strconv.Itoa(action.Estimate),
+			Value:  plainText(strconv.Itoa(action.Estimate)),
 			Inline: true,
 		})
 	}
 
-	return fields
+	if len(action.CustomFields) > 0 {
+		customFieldFields, err := getCustomFieldActionFields(clubhouseApiClient, action.CustomFields)
+		if err != nil {
+			return []EventField{}, err
+		}
+		fields = append(fields, customFieldFields...)
+	}
+
+	if len(action.LabelIds) > 0 {
+		labels, err := getLabelChips(clubhouseApiClient, referencesByTypeID, action.LabelIds)
+		if err != nil {
+			return []EventField{}, err
+		}
+		fields = append(fields, EventField{
+			Name:  "Labels",
+			Value: joinText(labels, " "),
+		})
+	}
+
+	return fields, nil
 }
 
 func getChangesFields(
 	clubhouseApiClient *ClubhouseApiClient,
 	referencesByTypeID map[string]ClubhouseReference,
 	changes ClubhouseChanges,
-) ([]Field, error) {
-	var fields []Field
+	options DiscordOptions,
+) ([]EventField, error) {
+	fields := getTransitionFields(changes)
+
+	if changes.Blocked != nil {
+		blockedValue := "🚫 Blocked"
+		if !changes.Blocked.New {
+			blockedValue = "Unblocked"
+		}
+		fields = append(fields, EventField{
+			Name:  "Blocked",
+			Value: plainText(blockedValue),
+		})
+	}
 
-	if changes.Deadline != nil {
-		oldDeadline := "No Date"
-		if changes.Deadline.Old != nil {
-			oldDeadline = changes.Deadline.Old.String()
+	if changes.Blocker != nil {
+		blockerValue := "⚠️ Flagged as a blocker"
+		if !changes.Blocker.New {
+			blockerValue = "No longer a blocker"
 		}
-		newDeadline := "No Date"
-		if changes.Deadline.New != nil {
-			newDeadline = changes.Deadline.New.String()
+		fields = append(fields, EventField{
+			Name:  "Blocker",
+			Value: plainText(blockerValue),
+		})
+	}
+
+	if changes.CustomFields != nil {
+		customFieldFields, err := getCustomFieldChangesFields(clubhouseApiClient, changes.CustomFields.Adds, changes.CustomFields.Removes)
+		if err != nil {
+			return []EventField{}, err
 		}
-		fields = append(fields, Field{
+		fields = append(fields, customFieldFields...)
+	}
+
+	if changes.Deadline != nil {
+		fields = append(fields, EventField{
 			Name:  "Deadline",
-			Value: fmt.Sprintf("%s -> %s", oldDeadline, newDeadline),
+			Value: concatText(options.Deadlines.Format(changes.Deadline.Old), plainText(" -> "), options.Deadlines.Format(changes.Deadline.New)),
+		})
+	}
+
+	// Stories send "description", and comments send "text".
+	if (changes.Description != nil && changes.Description.Old != changes.Description.New) ||
+		(changes.Text != nil && changes.Text.Old != changes.Text.New) {
+		fields = append(fields, EventField{
+			Name: "Description",
+			// Likely too long to include.
+			Value: plainText("(Edited)"),
 		})
 	}
 
 	if changes.EpicID != nil {
 		oldEpicValue := "None"
 		if changes.EpicID.Old != nil {
-			oldEpicTypeID := fmt.Sprintf("%s:%d", "epic", *changes.EpicID.Old)
-			oldEpic, ok := referencesByTypeID[oldEpicTypeID]
-			if ok {
-				oldEpicValue = oldEpic.Name
-			} else {
-				oldEpicValue = "Unknown"
-			}
+			oldEpicValue = resolveReferenceName(clubhouseApiClient, referencesByTypeID, "epic", *changes.EpicID.Old)
 		}
 		newEpicValue := "None"
 		if changes.EpicID.New != nil {
-			newEpicTypeID := fmt.Sprintf("%s:%d", "epic", *changes.EpicID.New)
-			newEpic, ok := referencesByTypeID[newEpicTypeID]
-			if ok {
-				newEpicValue = newEpic.Name
-			} else {
-				newEpicValue = "Unknown"
-			}
+			newEpicValue = resolveReferenceName(clubhouseApiClient, referencesByTypeID, "epic", *changes.EpicID.New)
 		}
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:  "Epic",
-			Value: fmt.Sprintf("%s -> %s", oldEpicValue, newEpicValue),
+			Value: plainText(fmt.Sprintf("%s -> %s", oldEpicValue, newEpicValue)),
 		})
 	}
 
@@ -509,167 +906,297 @@ func getChangesFields(
 		if changes.Estimate.New != nil {
 			newEstimateValue = strconv.Itoa(*changes.Estimate.New)
 		}
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:  "Estimate",
-			Value: fmt.Sprintf("%s -> %s", oldEstimateValue, newEstimateValue),
+			Value: plainText(fmt.Sprintf("%s -> %s", oldEstimateValue, newEstimateValue)),
+		})
+	}
+
+	if changes.ExternalLinks != nil {
+		if len(changes.ExternalLinks.Adds) > 0 {
+			fields = append(fields, EventField{
+				Name:  "External Link(s) Added",
+				Value: plainText(strings.Join(changes.ExternalLinks.Adds, "\n")),
+			})
+		}
+
+		if len(changes.ExternalLinks.Removes) > 0 {
+			fields = append(fields, EventField{
+				Name:  "External Link(s) Removed",
+				Value: plainText(strings.Join(changes.ExternalLinks.Removes, "\n")),
+			})
+		}
+	}
+
+	if changes.FileIds != nil || changes.LinkedFileIds != nil {
+		var filesAdded []string
+		var filesRemoved []string
+
+		if changes.FileIds != nil {
+			filesAdded = append(filesAdded, getReferenceNames(referencesByTypeID, "file", changes.FileIds.Adds)...)
+			filesRemoved = append(filesRemoved, getReferenceNames(referencesByTypeID, "file", changes.FileIds.Removes)...)
+		}
+		if changes.LinkedFileIds != nil {
+			filesAdded = append(filesAdded, getReferenceNames(referencesByTypeID, "linked-file", changes.LinkedFileIds.Adds)...)
+			filesRemoved = append(filesRemoved, getReferenceNames(referencesByTypeID, "linked-file", changes.LinkedFileIds.Removes)...)
+		}
+
+		if len(filesAdded) > 0 {
+			fields = append(fields, EventField{
+				Name:  "File(s) Added",
+				Value: plainText(strings.Join(filesAdded, ", ")),
+			})
+		}
+
+		if len(filesRemoved) > 0 {
+			fields = append(fields, EventField{
+				Name:  "File(s) Removed",
+				Value: plainText(strings.Join(filesRemoved, ", ")),
+			})
+		}
+	}
+
+	if changes.FollowerIds != nil {
+		if len(changes.FollowerIds.Adds) > 0 {
+			followersAdded, err := getMemberNames(clubhouseApiClient, changes.FollowerIds.Adds)
+			if err != nil {
+				return []EventField{}, err
+			}
+
+			fields = append(fields, EventField{
+				Name:  "Follower(s) Added",
+				Value: plainText(strings.Join(followersAdded, ", ")),
+			})
+		}
+
+		if len(changes.FollowerIds.Removes) > 0 {
+			followersRemoved, err := getMemberNames(clubhouseApiClient, changes.FollowerIds.Removes)
+			if err != nil {
+				return []EventField{}, err
+			}
+
+			fields = append(fields, EventField{
+				Name:  "Follower(s) Removed",
+				Value: plainText(strings.Join(followersRemoved, ", ")),
+			})
+		}
+	}
+
+	if changes.GroupID != nil {
+		oldGroupValue := "None"
+		if changes.GroupID.Old != nil {
+			oldGroup, err := clubhouseApiClient.GetGroup(*changes.GroupID.Old)
+			if err != nil {
+				return []EventField{}, err
+			}
+			oldGroupValue = oldGroup.Name
+		}
+		newGroupValue := "None"
+		if changes.GroupID.New != nil {
+			newGroup, err := clubhouseApiClient.GetGroup(*changes.GroupID.New)
+			if err != nil {
+				return []EventField{}, err
+			}
+			newGroupValue = newGroup.Name
+		}
+		fields = append(fields, EventField{
+			Name:  "Team",
+			Value: plainText(fmt.Sprintf("%s -> %s", oldGroupValue, newGroupValue)),
 		})
 	}
 
 	if changes.IterationID != nil {
 		oldIterationValue := "None"
 		if changes.IterationID.Old != nil {
-			oldIterationTypeID := fmt.Sprintf("%s:%d", "iteration", *changes.IterationID.Old)
-			oldIteration, ok := referencesByTypeID[oldIterationTypeID]
-			if ok {
-				oldIterationValue = oldIteration.Name
-			} else {
-				oldIterationValue = "Unknown"
-			}
+			oldIterationValue = resolveReferenceName(clubhouseApiClient, referencesByTypeID, "iteration", *changes.IterationID.Old)
 		}
 		newIterationValue := "None"
 		if changes.IterationID.New != nil {
-			newIterationTypeID := fmt.Sprintf("%s:%d", "iteration", *changes.IterationID.New)
-			newIteration, ok := referencesByTypeID[newIterationTypeID]
-			if ok {
-				newIterationValue = newIteration.Name
-			} else {
-				newIterationValue = "Unknown"
-			}
+			newIterationValue = resolveReferenceName(clubhouseApiClient, referencesByTypeID, "iteration", *changes.IterationID.New)
 		}
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:  "Iteration",
-			Value: fmt.Sprintf("%s -> %s", oldIterationValue, newIterationValue),
+			Value: plainText(fmt.Sprintf("%s -> %s", oldIterationValue, newIterationValue)),
 		})
 	}
 
 	if changes.LabelIds != nil {
 		if len(changes.LabelIds.Adds) > 0 {
-			labelsAdded := make([]string, len(changes.LabelIds.Adds))
-			for i, labelID := range changes.LabelIds.Adds {
-				labelTypeID := fmt.Sprintf("%s:%d", "label", labelID)
-				label, ok := referencesByTypeID[labelTypeID]
-				if ok {
-					labelsAdded[i] = label.Name
-				}
+			labelsAdded, err := getLabelChips(clubhouseApiClient, referencesByTypeID, changes.LabelIds.Adds)
+			if err != nil {
+				return []EventField{}, err
 			}
 
-			if len(labelsAdded) > 0 {
-				fields = append(fields, Field{
-					Name:  "Label(s) Added",
-					Value: strings.Join(labelsAdded, ", "),
-				})
-			}
+			fields = append(fields, EventField{
+				Name:  "Label(s) Added",
+				Value: joinText(labelsAdded, " "),
+			})
 		}
 
 		if len(changes.LabelIds.Removes) > 0 {
-			labelsRemoved := make([]string, len(changes.LabelIds.Removes))
-			for i, labelID := range changes.LabelIds.Removes {
-				labelTypeID := fmt.Sprintf("%s:%d", "label", labelID)
-				label, ok := referencesByTypeID[labelTypeID]
-				if ok {
-					labelsRemoved[i] = label.Name
-				}
+			labelsRemoved, err := getLabelChips(clubhouseApiClient, referencesByTypeID, changes.LabelIds.Removes)
+			if err != nil {
+				return []EventField{}, err
 			}
 
-			if len(labelsRemoved) > 0 {
-				fields = append(fields, Field{
-					Name:  "Label(s) Removed",
-					Value: strings.Join(labelsRemoved, ", "),
-				})
-			}
+			fields = append(fields, EventField{
+				Name:  "Label(s) Removed",
+				Value: joinText(labelsRemoved, " "),
+			})
 		}
 	}
 
+	if changes.Name != nil && changes.Name.Old != changes.Name.New {
+		fields = append(fields, EventField{
+			Name:  "Name",
+			Value: plainText(fmt.Sprintf("%s -> %s", changes.Name.Old, changes.Name.New)),
+		})
+	}
+
 	if changes.OwnerIds != nil {
 		if len(changes.OwnerIds.Adds) > 0 {
-			ownersAdded := make([]string, len(changes.OwnerIds.Adds))
-			for i, ownerID := range changes.OwnerIds.Adds {
-				member, err := clubhouseApiClient.GetMember(ownerID)
-				if err != nil {
-					return []Field{}, err
-				}
-				ownersAdded[i] = member.Profile.Name
+			ownersAdded, err := getMemberNames(clubhouseApiClient, changes.OwnerIds.Adds)
+			if err != nil {
+				return []EventField{}, err
 			}
 
-			fields = append(fields, Field{
+			fields = append(fields, EventField{
 				Name:  "Owner(s) Added",
-				Value: strings.Join(ownersAdded, ", "),
+				Value: plainText(strings.Join(ownersAdded, ", ")),
 			})
 		}
 
 		if len(changes.OwnerIds.Removes) > 0 {
-			ownersRemoved := make([]string, len(changes.OwnerIds.Removes))
-			for i, ownerID := range changes.OwnerIds.Removes {
-				member, err := clubhouseApiClient.GetMember(ownerID)
-				if err != nil {
-					return []Field{}, err
-				}
-				ownersRemoved[i] = member.Profile.Name
+			ownersRemoved, err := getMemberNames(clubhouseApiClient, changes.OwnerIds.Removes)
+			if err != nil {
+				return []EventField{}, err
 			}
 
-			fields = append(fields, Field{
+			fields = append(fields, EventField{
 				Name:  "Owner(s) Removed",
-				Value: strings.Join(ownersRemoved, ", "),
+				Value: plainText(strings.Join(ownersRemoved, ", ")),
 			})
 		}
 	}
 
-	if changes.ProjectID != nil {
-		oldProjectValue := "Unknown"
-		oldProjectTypeID := fmt.Sprintf("%s:%d", "project", changes.ProjectID.Old)
-		oldProject, ok := referencesByTypeID[oldProjectTypeID]
-		if ok {
-			oldProjectValue = oldProject.Name
+	if changes.Position != nil && options.ShowPositionChanges {
+		// Lower positions are higher up in the backlog.
+		positionValue := "Moved down"
+		if changes.Position.New < changes.Position.Old {
+			positionValue = "Moved up"
 		}
+		fields = append(fields, EventField{
+			Name:  "Priority",
+			Value: plainText(positionValue),
+		})
+	}
 
-		newProjectValue := "Unknown"
-		newProjectTypeID := fmt.Sprintf("%s:%d", "project", changes.ProjectID.New)
-		newProject, ok := referencesByTypeID[newProjectTypeID]
-		if ok {
-			newProjectValue = newProject.Name
-		}
+	if changes.ProjectID != nil {
+		oldProjectValue := resolveReferenceName(clubhouseApiClient, referencesByTypeID, "project", changes.ProjectID.Old)
+		newProjectValue := resolveReferenceName(clubhouseApiClient, referencesByTypeID, "project", changes.ProjectID.New)
 
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:  "Project",
-			Value: fmt.Sprintf("%s -> %s", oldProjectValue, newProjectValue),
+			Value: plainText(fmt.Sprintf("%s -> %s", oldProjectValue, newProjectValue)),
 		})
 	}
 
-	if changes.StoryType != nil {
-		fields = append(fields, Field{
-			Name:  "Type",
-			Value: strings.Title(fmt.Sprintf("%s -> %s", changes.StoryType.Old, changes.StoryType.New)),
+	if changes.RequestedByID != nil {
+		requesters, err := getMemberNames(clubhouseApiClient, []string{changes.RequestedByID.Old, changes.RequestedByID.New})
+		if err != nil {
+			return []EventField{}, err
+		}
+		fields = append(fields, EventField{
+			Name:  "Requester",
+			Value: plainText(fmt.Sprintf("%s -> %s", requesters[0], requesters[1])),
 		})
 	}
 
-	if changes.Text != nil && changes.Text.Old != changes.Text.New {
-		fields = append(fields, Field{
-			Name: "Description",
-			// Likely too long to include.
-			Value: "(Edited)",
+	if changes.StoryType != nil {
+		fields = append(fields, EventField{
+			Name:  "Type",
+			Value: plainText(strings.Title(fmt.Sprintf("%s -> %s", changes.StoryType.Old, changes.StoryType.New))),
 		})
 	}
 
-	if changes.WorkflowStateID != nil {
-		oldWorkflowStateValue := "Unknown"
-		oldWorkflowStateTypeID := fmt.Sprintf("%s:%d", "workflow-state", changes.WorkflowStateID.Old)
-		oldWorkflowState, ok := referencesByTypeID[oldWorkflowStateTypeID]
-		if ok {
-			oldWorkflowStateValue = oldWorkflowState.Name
+	if changes.TaskIds != nil {
+		if len(changes.TaskIds.Adds) > 0 {
+			fields = append(fields, EventField{
+				Name:  "Task(s) Added",
+				Value: plainText(getReferenceNamesOrCount(referencesByTypeID, "task", changes.TaskIds.Adds)),
+			})
 		}
 
-		newWorkflowStateValue := "Unknown"
-		newWorkflowStateTypeID := fmt.Sprintf("%s:%d", "workflow-state", changes.WorkflowStateID.New)
-		newWorkflowState, ok := referencesByTypeID[newWorkflowStateTypeID]
-		if ok {
-			newWorkflowStateValue = newWorkflowState.Name
+		if len(changes.TaskIds.Removes) > 0 {
+			fields = append(fields, EventField{
+				Name:  "Task(s) Removed",
+				Value: plainText(getReferenceNamesOrCount(referencesByTypeID, "task", changes.TaskIds.Removes)),
+			})
 		}
+	}
+
+	if changes.WorkflowStateID != nil {
+		oldWorkflowStateValue := getWorkflowStateValue(clubhouseApiClient, referencesByTypeID, changes.WorkflowStateID.Old, options)
+		newWorkflowStateValue := getWorkflowStateValue(clubhouseApiClient, referencesByTypeID, changes.WorkflowStateID.New, options)
 
-		fields = append(fields, Field{
+		fields = append(fields, EventField{
 			Name:  "State",
-			Value: strings.Title(fmt.Sprintf("%s -> %s", oldWorkflowStateValue, newWorkflowStateValue)),
+			Value: plainText(fmt.Sprintf("%s -> %s", oldWorkflowStateValue, newWorkflowStateValue)),
+		})
+	}
+
+	if len(changes.Unknown) > 0 {
+		fields = append(fields, EventField{
+			Name:  "Other Changes",
+			Value: plainText(strings.Join(getUnknownKeyNames(changes), ", ")),
 		})
 	}
 
 	return fields, nil
 }
+
+func getMemberNames(clubhouseApiClient *ClubhouseApiClient, memberIDs []string) ([]string, error) {
+	names := make([]string, len(memberIDs))
+
+	for i, memberID := range memberIDs {
+		if memberID == "" {
+			names[i] = "None"
+			continue
+		}
+
+		member, err := clubhouseApiClient.GetMember(memberID)
+		if err != nil {
+			return nil, err
+		}
+		names[i] = member.Profile.Name
+	}
+
+	return names, nil
+}
+
+// getReferenceNames returns the names of the referenced entities, or their IDs if they are not referenced.
+func getReferenceNames(referencesByTypeID map[string]ClubhouseReference, entityType string, ids []int) []string {
+	names := make([]string, len(ids))
+
+	for i, id := range ids {
+		typeID := fmt.Sprintf("%s:%d", entityType, id)
+		if reference, ok := referencesByTypeID[typeID]; ok && reference.Name != "" {
+			names[i] = reference.Name
+		} else {
+			names[i] = fmt.Sprintf("#%d", id)
+		}
+	}
+
+	return names
+}
+
+func getReferenceNamesOrCount(referencesByTypeID map[string]ClubhouseReference, entityType string, ids []int) string {
+	for _, id := range ids {
+		typeID := fmt.Sprintf("%s:%d", entityType, id)
+		if _, ok := referencesByTypeID[typeID]; !ok {
+			return strconv.Itoa(len(ids))
+		}
+	}
+
+	return strings.Join(getReferenceNames(referencesByTypeID, entityType, ids), ", ")
+}
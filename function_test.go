@@ -0,0 +1,105 @@
+package function
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func parseChanges(t *testing.T, rawChanges string) ClubhouseChanges {
+	t.Helper()
+
+	var changes ClubhouseChanges
+	if err := json.Unmarshal([]byte(rawChanges), &changes); err != nil {
+		t.Fatal(err)
+	}
+
+	return changes
+}
+
+func getFieldNames(fields []EventField) []string {
+	names := make([]string, len(fields))
+	for i, field := range fields {
+		names[i] = field.Name
+	}
+
+	return names
+}
+
+func TestGetChangesFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes string
+		options DiscordOptions
+		want    []string
+	}{
+		{
+			"description and text edited",
+			`{"description": {"old": "a", "new": "b"}, "text": {"old": "a", "new": "b"}}`,
+			DiscordOptions{},
+			[]string{"Description"},
+		},
+		{
+			"text edited",
+			`{"text": {"old": "a", "new": "b"}}`,
+			DiscordOptions{},
+			[]string{"Description"},
+		},
+		{
+			"position hidden",
+			`{"position": {"old": 2, "new": 1}, "name": {"old": "a", "new": "b"}}`,
+			DiscordOptions{},
+			[]string{"Name"},
+		},
+		{
+			"position shown",
+			`{"position": {"old": 2, "new": 1}}`,
+			DiscordOptions{ShowPositionChanges: true},
+			[]string{"Priority"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := getChangesFields(&ClubhouseApiClient{}, nil, parseChanges(t, test.changes), test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := getFieldNames(fields)
+			if len(got) != len(test.want) {
+				t.Fatalf("getChangesFields() = %v, want %v", got, test.want)
+			}
+			for i := range got {
+				if got[i] != test.want[i] {
+					t.Fatalf("getChangesFields() = %v, want %v", got, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsPositionChangeOnly(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions string
+		want    bool
+	}{
+		{"position", `[{"action": "update", "changes": {"position": {"old": 2, "new": 1}}}]`, true},
+		{"position and name", `[{"action": "update", "changes": {"position": {"old": 2, "new": 1}, "name": {"old": "a", "new": "b"}}}]`, false},
+		{"name", `[{"action": "update", "changes": {"name": {"old": "a", "new": "b"}}}]`, false},
+		{"create", `[{"action": "create"}]`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var webhook ClubhouseWebhook
+			if err := json.Unmarshal([]byte(`{"actions": `+test.actions+`}`), &webhook); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := isPositionChangeOnly(webhook); got != test.want {
+				t.Errorf("isPositionChangeOnly() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Discord rejects field values longer than this.
+const maxFieldValueLength = 1024
+
+// Discord rejects webhooks with more embeds than this.
+const maxEmbeds = 10
+
+// truncateText shortens text to at most maxLength characters (not bytes, so that multi-byte characters are
+// never split), ending it with "..." when it is cut.
+func truncateText(text string, maxLength int) string {
+	if utf8.RuneCountInString(text) <= maxLength {
+		return text
+	}
+
+	runes := []rune(text)
+	return string(runes[:maxLength-3]) + "..."
+}
+
+// toGenericEvent renders a best-effort item per action, for events that are not otherwise handled.
+func toGenericEvent(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook, options DiscordOptions) (*Event, error) {
+	referencesByTypeID := getReferencesByTypeID(webhook)
+
+	var items []EventItem
+
+	for _, action := range webhook.Actions {
+		if len(items) == maxEmbeds {
+			break
+		}
+
+		name := action.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", action.ID)
+		}
+
+		entityType := strings.ReplaceAll(action.EntityType, "-", " ")
+		if entityType == "" {
+			entityType = "entity"
+		}
+
+		verb := action.Action + "d"
+		if action.Action == "" {
+			verb = "changed"
+		}
+
+		webhookTitle, err := getWebhookTitle(clubhouseApiClient, options, webhook.MemberID, verb, entityType, name)
+		if err != nil {
+			return nil, err
+		}
+
+		webhookURL := action.AppURL
+		if webhookURL == "" {
+			webhookURL = action.URL
+		}
+
+		items = append(items, EventItem{
+			Title:  webhookTitle,
+			URL:    webhookURL,
+			Colour: 9807270,
+			Fields: getGenericChangesFields(clubhouseApiClient, referencesByTypeID, action.Changes),
+		})
+	}
+
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	return &Event{
+		Items: items,
+	}, nil
+}
+
+func getGenericChangesFields(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	changes ClubhouseChanges,
+) []EventField {
+	keys := make([]string, 0, len(changes.Generic))
+	for key := range changes.Generic {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var fields []EventField
+
+	for _, key := range keys {
+		change := changes.Generic[key]
+
+		var lines []string
+		if change.Old != nil || change.New != nil {
+			lines = append(lines, fmt.Sprintf(
+				"%s -> %s",
+				getGenericValue(clubhouseApiClient, referencesByTypeID, key, change.Old),
+				getGenericValue(clubhouseApiClient, referencesByTypeID, key, change.New),
+			))
+		}
+		if len(change.Adds) > 0 {
+			lines = append(lines, "Added: "+getGenericValues(clubhouseApiClient, referencesByTypeID, key, change.Adds))
+		}
+		if len(change.Removes) > 0 {
+			lines = append(lines, "Removed: "+getGenericValues(clubhouseApiClient, referencesByTypeID, key, change.Removes))
+		}
+
+		if len(lines) == 0 {
+			continue
+		}
+
+		value := strings.Join(lines, "\n")
+		value = truncateText(value, maxFieldValueLength)
+
+		fields = append(fields, EventField{
+			Name:  getGenericKeyName(key),
+			Value: plainText(value),
+		})
+	}
+
+	return fields
+}
+
+// getGenericKeyName turns a change key into a field name, e.g. "workflow_state_id" into "Workflow State".
+func getGenericKeyName(key string) string {
+	key = strings.TrimSuffix(strings.TrimSuffix(key, "_ids"), "_id")
+	return strings.Title(strings.ReplaceAll(key, "_", " "))
+}
+
+func getGenericValues(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	key string,
+	rawValues []json.RawMessage,
+) string {
+	values := make([]string, len(rawValues))
+	for i, rawValue := range rawValues {
+		values[i] = getGenericValue(clubhouseApiClient, referencesByTypeID, key, rawValue)
+	}
+
+	return strings.Join(values, ", ")
+}
+
+// getGenericValue renders a raw change value, resolving "*_id(s)" values to names where it can.
+func getGenericValue(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	key string,
+	rawValue json.RawMessage,
+) string {
+	if len(rawValue) == 0 || string(rawValue) == "null" {
+		return "None"
+	}
+
+	isID := strings.HasSuffix(key, "_id") || strings.HasSuffix(key, "_ids")
+	entityType := strings.ReplaceAll(strings.TrimSuffix(strings.TrimSuffix(key, "_ids"), "_id"), "_", "-")
+
+	var stringValue string
+	if err := json.Unmarshal(rawValue, &stringValue); err == nil {
+		if isID {
+			if name, ok := resolveGenericUUID(clubhouseApiClient, entityType, stringValue); ok {
+				return name
+			}
+		}
+		return stringValue
+	}
+
+	var intValue int
+	if err := json.Unmarshal(rawValue, &intValue); err == nil {
+		if isID {
+			return resolveReferenceName(clubhouseApiClient, referencesByTypeID, entityType, intValue)
+		}
+		return strconv.Itoa(intValue)
+	}
+
+	var compactValue bytes.Buffer
+	if err := json.Compact(&compactValue, rawValue); err != nil {
+		return string(rawValue)
+	}
+
+	return compactValue.String()
+}
+
+// resolveGenericUUID resolves groups and members (owners, followers, requesters, etc.), which use UUIDs.
+func resolveGenericUUID(clubhouseApiClient *ClubhouseApiClient, entityType string, id string) (string, bool) {
+	switch entityType {
+	case "group":
+		group, err := clubhouseApiClient.GetGroup(id)
+		if err != nil {
+			log.Println("failed to resolve group:", err)
+			return "", false
+		}
+		return group.Name, true
+	case "author", "follower", "member", "mention", "owner", "requested-by":
+		member, err := clubhouseApiClient.GetMember(id)
+		if err != nil {
+			log.Println("failed to resolve member:", err)
+			return "", false
+		}
+		return member.Profile.Name, true
+	default:
+		return "", false
+	}
+}
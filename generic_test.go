@@ -0,0 +1,41 @@
+package function
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncateText(t *testing.T) {
+	tests := []struct {
+		name      string
+		text      string
+		maxLength int
+		want      string
+	}{
+		{"short", "abc", 5, "abc"},
+		{"exact", "abcde", 5, "abcde"},
+		{"long", "abcdefgh", 5, "ab..."},
+		{"multi-byte", "ééééééé", 5, "éé..."},
+		{"emoji", "🚫🚫🚫🚫🚫🚫", 4, "🚫..."},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := truncateText(test.text, test.maxLength)
+			if got != test.want {
+				t.Errorf("truncateText() = %q, want %q", got, test.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncateText() = %q, which is not valid UTF-8", got)
+			}
+		})
+	}
+}
+
+func TestTruncateTextFieldValue(t *testing.T) {
+	value := truncateText(strings.Repeat("ü", maxFieldValueLength*2), maxFieldValueLength)
+	if n := utf8.RuneCountInString(value); n != maxFieldValueLength {
+		t.Errorf("truncateText() has %d characters, want %d", n, maxFieldValueLength)
+	}
+}
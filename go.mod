@@ -0,0 +1,8 @@
+module github.com/Courtsite/clubhouse-to-discord
+
+go 1.13
+
+require (
+	github.com/BurntSushi/toml v0.3.1
+	gopkg.in/yaml.v3 v3.0.1
+)
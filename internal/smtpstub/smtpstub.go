@@ -0,0 +1,102 @@
+// Package smtpstub is a stand-in for an SMTP server, which accepts every message (and any credentials). It is
+// used by cmd/smtp-stub and by the tests of the email sink.
+package smtpstub
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// A Message is an email as received, with the addresses of the envelope.
+type Message struct {
+	From string
+	To   []string
+	Data string
+}
+
+// Serve accepts connections until the listener is closed, calling handle with each message received.
+func Serve(listener net.Listener, handle func(Message)) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go ServeConn(conn, handle)
+	}
+}
+
+// ServeConn speaks just enough SMTP for net/smtp's SendMail.
+// https://www.rfc-editor.org/rfc/rfc5321#section-4.1
+func ServeConn(conn net.Conn, handle func(Message)) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	var from string
+	var to []string
+
+	reply("220 localhost smtp-stub")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch command {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "HELO", "NOOP":
+			reply("250 OK")
+		case "AUTH":
+			reply("235 Authentication successful")
+		case "MAIL":
+			from = trimAddress(strings.TrimPrefix(line[len("MAIL"):], " FROM:"))
+			to = nil
+			reply("250 OK")
+		case "RCPT":
+			to = append(to, trimAddress(strings.TrimPrefix(line[len("RCPT"):], " TO:")))
+			reply("250 OK")
+		case "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				// https://www.rfc-editor.org/rfc/rfc5321#section-4.5.2
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+
+			handle(Message{From: from, To: to, Data: data.String()})
+			reply("250 OK")
+		case "RSET":
+			from, to = "", nil
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("502 Command not implemented")
+		}
+	}
+}
+
+// trimAddress removes the angle brackets around an address, and any parameters after it.
+func trimAddress(path string) string {
+	path = strings.SplitN(strings.TrimSpace(path), " ", 2)[0]
+	return strings.TrimSuffix(strings.TrimPrefix(path, "<"), ">")
+}
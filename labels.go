@@ -0,0 +1,164 @@
+package function
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type labelSwatch struct {
+	emoji string
+	red   int
+	green int
+	blue  int
+}
+
+// Discord cannot colour text, so label colours are approximated with the closest coloured square.
+var labelSwatches = []labelSwatch{
+	{"🟥", 221, 46, 68},
+	{"🟧", 244, 144, 12},
+	{"🟨", 253, 203, 88},
+	{"🟩", 120, 177, 89},
+	{"🟦", 85, 172, 238},
+	{"🟪", 170, 142, 214},
+	{"🟫", 193, 105, 79},
+	{"⬛", 49, 55, 61},
+	{"⬜", 230, 231, 232},
+}
+
+// parseLabelColour parses a Clubhouse label colour (e.g. "#e2a04b") into a Discord embed colour.
+func parseLabelColour(colour string) (int, bool) {
+	colour = strings.TrimPrefix(strings.TrimSpace(colour), "#")
+	if len(colour) != 6 {
+		return 0, false
+	}
+
+	value, err := strconv.ParseInt(colour, 16, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return int(value), true
+}
+
+func getLabelSwatch(colour int) string {
+	red := (colour >> 16) & 0xff
+	green := (colour >> 8) & 0xff
+	blue := colour & 0xff
+
+	var closest string
+	closestDistance := -1
+
+	for _, swatch := range labelSwatches {
+		distance := (red-swatch.red)*(red-swatch.red) +
+			(green-swatch.green)*(green-swatch.green) +
+			(blue-swatch.blue)*(blue-swatch.blue)
+		if closestDistance < 0 || distance < closestDistance {
+			closest = swatch.emoji
+			closestDistance = distance
+		}
+	}
+
+	return closest
+}
+
+func getLabelChip(name string, colour string) Text {
+	if value, ok := parseLabelColour(colour); ok {
+		return concatText(plainText(getLabelSwatch(value)+" "), codeText(name))
+	}
+
+	return codeText(name)
+}
+
+// getLabelChips resolves labels from the webhook references, falling back to the Clubhouse API.
+func getLabelChips(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	labelIDs []int,
+) ([]Text, error) {
+	labels := make([]Text, len(labelIDs))
+
+	for i, labelID := range labelIDs {
+		label, err := resolveReference(clubhouseApiClient, referencesByTypeID, "label", labelID)
+		if err != nil {
+			return nil, err
+		}
+		labels[i] = getLabelChip(label.Name, label.Color)
+	}
+
+	return labels, nil
+}
+
+func toLabelEvent(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook, action ClubhouseAction, options DiscordOptions) (*Event, error) {
+	var fields []EventField
+	var colour int
+	verb := action.Action + "d"
+
+	switch action.Action {
+	case "create":
+		colour = 5424154
+		if action.Description != "" {
+			fields = append(fields, EventField{
+				Name:  "Description",
+				Value: plainText(action.Description),
+			})
+		}
+	case "update":
+		colour = 16440084
+		changes := action.Changes
+
+		if changes.Archived != nil {
+			if changes.Archived.New {
+				verb = "archived"
+			} else {
+				verb = "unarchived"
+			}
+		}
+
+		if changes.Name != nil {
+			fields = append(fields, EventField{
+				Name:  "Name",
+				Value: plainText(fmt.Sprintf("%s -> %s", changes.Name.Old, changes.Name.New)),
+			})
+		}
+
+		if changes.Color != nil {
+			fields = append(fields, EventField{
+				Name:  "Colour",
+				Value: concatText(getLabelChip(changes.Color.Old, changes.Color.Old), plainText(" -> "), getLabelChip(changes.Color.New, changes.Color.New)),
+			})
+		}
+
+		if changes.Archived == nil && len(fields) == 0 {
+			return nil, nil
+		}
+	case "delete":
+		colour = 16065069
+	default:
+		return nil, nil
+	}
+
+	if labelColour, ok := parseLabelColour(action.Color); ok && action.Action != "delete" {
+		colour = labelColour
+	}
+
+	if action.Name == "" || action.AppURL == "" {
+		return nil, nil
+	}
+
+	webhookTitle, err := getWebhookTitle(clubhouseApiClient, options, webhook.MemberID, verb, action.EntityType, action.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		Items: []EventItem{
+			{
+				Title:  webhookTitle,
+				URL:    action.AppURL,
+				Colour: colour,
+				Fields: fields,
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,165 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// https://spec.matrix.org/v1.2/client-server-api/#mroommessage
+type MatrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+}
+
+// MatrixSink posts events to a Matrix room with the client-server API. Homeserver may be http:// for a local
+// stub homeserver.
+type MatrixSink struct {
+	SinkName    string
+	Homeserver  string
+	RoomID      string
+	AccessToken string
+}
+
+func (s *MatrixSink) Name() string {
+	return s.SinkName
+}
+
+// https://spec.matrix.org/v1.2/client-server-api/#put_matrixclientv3roomsroomidsendeventtypetxnid
+func (s *MatrixSink) Send(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(toMatrixMessage(event))
+	if err != nil {
+		return err
+	}
+
+	sendURL := fmt.Sprintf(
+		"%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(s.Homeserver, "/"),
+		url.PathEscape(s.RoomID),
+		getMatrixTxnID(event, payload),
+	)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, sendURL, bytes.NewBuffer(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.AccessToken)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		data, _ := ioutil.ReadAll(res.Body)
+		log.Println("payload", string(payload))
+		return &SinkStatusError{StatusCode: res.StatusCode, Body: string(data)}
+	}
+
+	return nil
+}
+
+// getMatrixTxnID derives the transaction ID from the event, so that every attempt to send it (including when
+// Clubhouse resends the webhook) has the same ID, and the homeserver posts it once.
+func getMatrixTxnID(event Event, payload []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(event.ID))
+	hash.Write([]byte{0})
+	hash.Write(payload)
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func validateMatrixSinkConfig(config SinkConfig) error {
+	homeserverURL, err := url.Parse(config.Homeserver)
+	if err != nil || (homeserverURL.Scheme != "https" && homeserverURL.Scheme != "http") || homeserverURL.Host == "" {
+		return fmt.Errorf("homeserver: must be a URL, e.g. https://matrix.example.com")
+	}
+
+	// https://spec.matrix.org/v1.2/appendices/#room-ids-and-event-ids
+	if !strings.HasPrefix(config.RoomID, "!") || !strings.Contains(config.RoomID, ":") {
+		return fmt.Errorf("room_id: must be a room ID, e.g. !abc123:example.com, got %q", config.RoomID)
+	}
+
+	if config.AccessToken == "" {
+		return fmt.Errorf("access_token: is required")
+	}
+
+	return nil
+}
+
+func toMatrixMessage(event Event) MatrixMessage {
+	var body []string
+	var formattedBody []string
+
+	if len(event.Content) > 0 {
+		body = append(body, event.Content.String())
+		formattedBody = append(formattedBody, "<p>"+toHTML(event.Content)+"</p>")
+	}
+
+	for _, item := range event.Items {
+		title := item.Title
+		htmlTitle := html.EscapeString(item.Title)
+		if item.URL != "" {
+			title = fmt.Sprintf("%s (%s)", title, item.URL)
+			htmlTitle = fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(item.URL), htmlTitle)
+		}
+
+		itemBody := []string{title}
+		itemHTML := fmt.Sprintf(`<h4><font data-mx-color="#%06x">■</font> %s</h4>`, item.Colour, htmlTitle)
+
+		if item.Description != "" {
+			itemBody = append(itemBody, item.Description)
+			itemHTML += "<p>" + toHTML(plainText(item.Description)) + "</p>"
+		}
+
+		if len(item.Fields) > 0 {
+			itemHTML += "<ul>"
+			for _, field := range item.Fields {
+				itemBody = append(itemBody, fmt.Sprintf("%s: %s", field.Name, field.Value))
+				itemHTML += fmt.Sprintf("<li><strong>%s</strong>: %s</li>", html.EscapeString(field.Name), toHTML(field.Value))
+			}
+			itemHTML += "</ul>"
+		}
+
+		body = append(body, strings.Join(itemBody, "\n"))
+		formattedBody = append(formattedBody, itemHTML)
+	}
+
+	return MatrixMessage{
+		// Notices are not replied to by bots.
+		MsgType:       "m.notice",
+		Body:          strings.Join(body, "\n\n"),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: strings.Join(formattedBody, ""),
+	}
+}
+
+// toHTML writes text as HTML, for Matrix and email.
+func toHTML(text Text) string {
+	formatted := text.format(textFormat{
+		plain: html.EscapeString,
+		bold:  func(text string) string { return "<strong>" + html.EscapeString(text) + "</strong>" },
+		code:  func(text string) string { return "<code>" + html.EscapeString(text) + "</code>" },
+		link: func(text string, url string) string {
+			return fmt.Sprintf(`<a href="%s">%s</a>`, html.EscapeString(url), html.EscapeString(text))
+		},
+		time: func(kind TextSpanKind, t time.Time) string { return html.EscapeString(formatPlainTime(kind, t)) },
+	})
+
+	return strings.ReplaceAll(formatted, "\n", "<br>")
+}
@@ -0,0 +1,144 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// matrixStub is a stub homeserver, which posts each transaction once and fails the first failures requests.
+type matrixStub struct {
+	sync.Mutex
+	failures int
+	requests int
+	txnIDs   []string
+	messages map[string]MatrixMessage
+}
+
+func (s *matrixStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	prefix := "/_matrix/client/v3/rooms/!room:example.com/send/m.room.message/"
+	if r.Method != http.MethodPut || !strings.HasPrefix(r.URL.Path, prefix) {
+		http.Error(w, `{"errcode":"M_UNRECOGNIZED"}`, http.StatusNotFound)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer token" {
+		http.Error(w, `{"errcode":"M_UNKNOWN_TOKEN"}`, http.StatusUnauthorized)
+		return
+	}
+
+	txnID := strings.TrimPrefix(r.URL.Path, prefix)
+	s.txnIDs = append(s.txnIDs, txnID)
+	s.requests++
+
+	var message MatrixMessage
+	if err := json.NewDecoder(r.Body).Decode(&message); err != nil {
+		http.Error(w, `{"errcode":"M_NOT_JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if _, ok := s.messages[txnID]; !ok {
+		s.messages[txnID] = message
+	}
+
+	if s.requests <= s.failures {
+		http.Error(w, `{"errcode":"M_UNKNOWN"}`, http.StatusBadGateway)
+		return
+	}
+
+	w.Write([]byte(`{"event_id":"$event"}`))
+}
+
+func newMatrixStub(failures int) (*matrixStub, *httptest.Server) {
+	stub := &matrixStub{failures: failures, messages: make(map[string]MatrixMessage)}
+	return stub, httptest.NewServer(stub)
+}
+
+func newTestMatrixEvent(id string) Event {
+	return Event{
+		ID:      id,
+		Content: boldText("Blocked"),
+		Items: []EventItem{
+			{
+				Title:  "Alice updated story: Fix the login page",
+				URL:    "https://app.clubhouse.io/workspace/story/1",
+				Colour: 16440084,
+				Fields: []EventField{{Name: "State", Value: plainText("Ready -> In Review")}},
+			},
+		},
+	}
+}
+
+func TestMatrixSinkSend(t *testing.T) {
+	stub, server := newMatrixStub(0)
+	defer server.Close()
+
+	sink := &MatrixSink{SinkName: "matrix", Homeserver: server.URL + "/", RoomID: "!room:example.com", AccessToken: "token"}
+	if err := sink.Send(context.Background(), newTestMatrixEvent("webhook:1")); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	if len(stub.messages) != 1 {
+		t.Fatalf("messages = %d, want 1", len(stub.messages))
+	}
+	message := stub.messages[stub.txnIDs[0]]
+	if message.MsgType != "m.notice" || message.Format != "org.matrix.custom.html" {
+		t.Errorf("message = %+v, want an HTML notice", message)
+	}
+	if !strings.Contains(message.Body, "State: Ready -> In Review") {
+		t.Errorf("Body = %q, want the fields", message.Body)
+	}
+	if !strings.Contains(message.FormattedBody, "<strong>Blocked</strong>") {
+		t.Errorf("FormattedBody = %q, want the content", message.FormattedBody)
+	}
+
+	sink.AccessToken = "other"
+	if err := sink.Send(context.Background(), newTestMatrixEvent("webhook:1")); err == nil || isTemporarySinkError(err) {
+		t.Errorf("Send() = %v, want a permanent error for an unknown token", err)
+	}
+}
+
+func TestMatrixSinkRetriesUseTheSameTxnID(t *testing.T) {
+	stub, server := newMatrixStub(2)
+	defer server.Close()
+
+	sink := ConfiguredSink{
+		Sink:   &MatrixSink{SinkName: "matrix", Homeserver: server.URL, RoomID: "!room:example.com", AccessToken: "token"},
+		Policy: DeliveryPolicy{Timeout: time.Second, Retries: 2, RetryBackoff: time.Millisecond},
+	}
+
+	result := deliverToSink(sink, newTestMatrixEvent("webhook:1"))
+	if !result.Sent || result.Attempts != 3 {
+		t.Fatalf("deliverToSink() = %+v, want it sent on the third attempt", result)
+	}
+	if stub.txnIDs[0] != stub.txnIDs[1] || stub.txnIDs[1] != stub.txnIDs[2] {
+		t.Errorf("txn IDs = %v, want the same ID for every attempt", stub.txnIDs)
+	}
+	if len(stub.messages) != 1 {
+		t.Errorf("messages = %d, want the event posted once", len(stub.messages))
+	}
+
+	// Clubhouse resending the webhook is the same transaction, but other webhooks are not.
+	deliverToSink(sink, newTestMatrixEvent("webhook:1"))
+	deliverToSink(sink, newTestMatrixEvent("webhook:2"))
+	if len(stub.messages) != 2 {
+		t.Errorf("messages = %d, want 2", len(stub.messages))
+	}
+
+	// Neither are identical standups of different days.
+	monday := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	for _, now := range []time.Time{monday, monday.AddDate(0, 0, 1)} {
+		events := toStandupEvents([]standupReport{{MemberName: "Alice"}}, now)
+		events[0].Content = plainText("Standup")
+		deliverToSink(sink, events[0])
+	}
+	if len(stub.messages) != 4 {
+		t.Errorf("messages = %d, want a standup for each day", len(stub.messages))
+	}
+}
@@ -0,0 +1,214 @@
+package function
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The version of the normalized event schema. It is only increased for changes that are not backwards compatible
+// (fields may be added without increasing it).
+const normalizedEventSchemaVersion = 1
+
+// NormalizedEvent is the JSON posted by the webhook sink: a Clubhouse webhook with its members and references resolved.
+type NormalizedEvent struct {
+	SchemaVersion int                `json:"schema_version"`
+	ID            string             `json:"id"`
+	ChangedAt     time.Time          `json:"changed_at"`
+	Actor         *NormalizedMember  `json:"actor"`
+	Actions       []NormalizedAction `json:"actions"`
+	// The rendered title of each action, e.g. "Alice updated story: Fix the login page".
+	Summaries []string `json:"summaries"`
+}
+
+type NormalizedMember struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type NormalizedAction struct {
+	// e.g. "create", "update" or "delete".
+	Action  string             `json:"action"`
+	Entity  NormalizedEntity   `json:"entity"`
+	Changes []NormalizedChange `json:"changes"`
+}
+
+type NormalizedEntity struct {
+	// e.g. "story", "epic" or "pull-request".
+	Type string `json:"type"`
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// A link to the entity in Clubhouse (or the VCS provider, for branches, commits and pull requests).
+	URL string `json:"url,omitempty"`
+}
+
+// NormalizedChange is a changed field. Fields with a single value have Old and New, and fields with a list of
+// values have Added and Removed.
+type NormalizedChange struct {
+	// The Clubhouse field, e.g. "workflow_state_id".
+	Field   string            `json:"field"`
+	Old     *NormalizedValue  `json:"old,omitempty"`
+	New     *NormalizedValue  `json:"new,omitempty"`
+	Added   []NormalizedValue `json:"added,omitempty"`
+	Removed []NormalizedValue `json:"removed,omitempty"`
+}
+
+// NormalizedValue is a value as Clubhouse sent it, with the name it was resolved to for IDs.
+type NormalizedValue struct {
+	Value json.RawMessage `json:"value"`
+	Name  string          `json:"name,omitempty"`
+}
+
+func newNormalizedEvent(clubhouseApiClient *ClubhouseApiClient, webhook ClubhouseWebhook, summaries []string) (*NormalizedEvent, error) {
+	referencesByTypeID := getReferencesByTypeID(webhook)
+
+	event := &NormalizedEvent{
+		SchemaVersion: normalizedEventSchemaVersion,
+		ID:            webhook.ID,
+		ChangedAt:     webhook.ChangedAt,
+		Actions:       make([]NormalizedAction, len(webhook.Actions)),
+		Summaries:     summaries,
+	}
+
+	if webhook.MemberID != "" {
+		memberNames, err := getMemberNames(clubhouseApiClient, []string{webhook.MemberID})
+		if err != nil {
+			return nil, err
+		}
+		event.Actor = &NormalizedMember{ID: webhook.MemberID, Name: memberNames[0]}
+	}
+
+	for i, action := range webhook.Actions {
+		entityURL := action.AppURL
+		if entityURL == "" {
+			entityURL = action.URL
+		}
+
+		event.Actions[i] = NormalizedAction{
+			Action: action.Action,
+			Entity: NormalizedEntity{
+				Type: action.EntityType,
+				ID:   action.ID,
+				Name: action.Name,
+				URL:  entityURL,
+			},
+			Changes: getNormalizedChanges(clubhouseApiClient, referencesByTypeID, action.Changes),
+		}
+	}
+
+	return event, nil
+}
+
+func getNormalizedChanges(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	changes ClubhouseChanges,
+) []NormalizedChange {
+	keys := make([]string, 0, len(changes.Generic))
+	for key := range changes.Generic {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	normalizedChanges := make([]NormalizedChange, 0, len(keys))
+
+	for _, key := range keys {
+		change := changes.Generic[key]
+		normalizedChange := NormalizedChange{Field: key}
+
+		if change.Old != nil || change.New != nil {
+			oldValue := getNormalizedValue(clubhouseApiClient, referencesByTypeID, key, change.Old)
+			newValue := getNormalizedValue(clubhouseApiClient, referencesByTypeID, key, change.New)
+			normalizedChange.Old = &oldValue
+			normalizedChange.New = &newValue
+		}
+		for _, rawValue := range change.Adds {
+			normalizedChange.Added = append(normalizedChange.Added, getNormalizedValue(clubhouseApiClient, referencesByTypeID, key, rawValue))
+		}
+		for _, rawValue := range change.Removes {
+			normalizedChange.Removed = append(normalizedChange.Removed, getNormalizedValue(clubhouseApiClient, referencesByTypeID, key, rawValue))
+		}
+
+		normalizedChanges = append(normalizedChanges, normalizedChange)
+	}
+
+	return normalizedChanges
+}
+
+// getNormalizedValue resolves "*_id(s)" values to names, like the generic renderer.
+func getNormalizedValue(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	key string,
+	rawValue json.RawMessage,
+) NormalizedValue {
+	if len(rawValue) == 0 {
+		rawValue = json.RawMessage("null")
+	}
+
+	value := NormalizedValue{Value: rawValue}
+	if string(rawValue) != "null" && (strings.HasSuffix(key, "_id") || strings.HasSuffix(key, "_ids")) {
+		value.Name = getGenericValue(clubhouseApiClient, referencesByTypeID, key, rawValue)
+	}
+
+	return value
+}
+
+// WebhookSink posts normalized events to any URL, for other tooling. When a secret is set, requests have the time
+// they were sent in X-Webhook-Timestamp, and "sha256=" and the hex HMAC-SHA256 of "{timestamp}.{body}" in
+// X-Webhook-Signature. Unlike Clubhouse's Payload-Signature, the timestamp is signed, so that receivers can reject
+// old requests.
+type WebhookSink struct {
+	SinkName string
+	URL      string
+	Secret   string
+}
+
+func (s *WebhookSink) Name() string {
+	return s.SinkName
+}
+
+func (s *WebhookSink) Send(ctx context.Context, event Event) error {
+	if event.Normalized == nil {
+		return &PermanentSinkError{Err: fmt.Errorf("the event could not be normalized")}
+	}
+
+	payload, err := json.Marshal(event.Normalized)
+	if err != nil {
+		return err
+	}
+
+	headers := make(map[string]string)
+	if s.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		headers["X-Webhook-Timestamp"] = timestamp
+		headers["X-Webhook-Signature"] = "sha256=" + signWebhookPayload(s.Secret, timestamp, payload)
+	}
+
+	return postJSONWithHeaders(ctx, s.URL, payload, headers)
+}
+
+// signWebhookPayload signs "{timestamp}.{payload}", so that receivers can reject old requests.
+func signWebhookPayload(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write([]byte(timestamp + "."))
+	_, _ = mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func validateWebhookSinkURL(webhookURL string) error {
+	parsedURL, err := url.Parse(webhookURL)
+	if err != nil || (parsedURL.Scheme != "https" && parsedURL.Scheme != "http") || parsedURL.Host == "" {
+		return fmt.Errorf("must be an http(s) URL")
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package function
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSinkSignsRequests(t *testing.T) {
+	var timestamp, signature string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp = r.Header.Get("X-Webhook-Timestamp")
+		signature = r.Header.Get("X-Webhook-Signature")
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	sink := &WebhookSink{SinkName: "webhook", URL: server.URL, Secret: "secret"}
+	event := Event{Normalized: &NormalizedEvent{SchemaVersion: 1, ID: "webhook"}}
+	if err := sink.Send(context.Background(), event); err != nil {
+		t.Fatalf("Send() = %v", err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); timestamp == "" || signature != want {
+		t.Errorf("X-Webhook-Signature = %q, want %q for timestamp %q", signature, want, timestamp)
+	}
+}
+
+func TestWebhookSinkDoesNotRetryEventsThatCouldNotBeNormalized(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	sink := ConfiguredSink{
+		Sink:   &WebhookSink{SinkName: "webhook", URL: server.URL},
+		Policy: DeliveryPolicy{Timeout: time.Second, Retries: 2, RetryBackoff: time.Millisecond},
+	}
+
+	result := deliverToSink(sink, Event{})
+	if result.Sent || result.Attempts != 1 || result.Error != "the event could not be normalized" {
+		t.Errorf("deliverToSink() = %+v, want a single failed attempt", result)
+	}
+	if requests != 0 {
+		t.Errorf("requests = %d, want none", requests)
+	}
+}
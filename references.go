@@ -0,0 +1,235 @@
+package function
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// How long entities resolved via the API are cached for, unless REFERENCE_CACHE_TTL is set. Renames are picked up
+// after this long.
+const defaultReferenceCacheTTL = 10 * time.Minute
+
+func (c *ClubhouseApiClient) getCacheTTL() time.Duration {
+	if c.CacheTTL > 0 {
+		return c.CacheTTL
+	}
+
+	return defaultReferenceCacheTTL
+}
+
+type cachedReference struct {
+	Reference ClubhouseReference
+	CachedAt  time.Time
+}
+
+// referenceCache holds entities resolved via the API, so that a warm instance does not fetch them for every webhook.
+var referenceCache = struct {
+	sync.Mutex
+	referencesByKey map[string]cachedReference
+}{
+	referencesByKey: make(map[string]cachedReference),
+}
+
+// workspaceCache holds one value per workspace (e.g. its workflows), fetched via the API at most once per
+// cache TTL. Concurrent fetches for a workspace wait for the first one, without blocking other workspaces.
+type workspaceCache struct {
+	sync.Mutex
+	valuesByToken   map[string]interface{}
+	cachedAtByToken map[string]time.Time
+	fetchesByToken  map[string]*workspaceFetch
+}
+
+type workspaceFetch struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+func newWorkspaceCache() *workspaceCache {
+	return &workspaceCache{
+		valuesByToken:   make(map[string]interface{}),
+		cachedAtByToken: make(map[string]time.Time),
+		fetchesByToken:  make(map[string]*workspaceFetch),
+	}
+}
+
+func (c *workspaceCache) get(clubhouseApiClient *ClubhouseApiClient, fetch func() (interface{}, error)) (interface{}, error) {
+	token := clubhouseApiClient.ApiToken
+
+	c.Lock()
+	if cachedAt, ok := c.cachedAtByToken[token]; ok && time.Since(cachedAt) <= clubhouseApiClient.getCacheTTL() {
+		value := c.valuesByToken[token]
+		c.Unlock()
+		return value, nil
+	}
+	if inFlight, ok := c.fetchesByToken[token]; ok {
+		c.Unlock()
+		<-inFlight.done
+		return inFlight.value, inFlight.err
+	}
+	inFlight := &workspaceFetch{done: make(chan struct{})}
+	c.fetchesByToken[token] = inFlight
+	c.Unlock()
+
+	inFlight.value, inFlight.err = fetch()
+
+	c.Lock()
+	delete(c.fetchesByToken, token)
+	if inFlight.err == nil {
+		c.valuesByToken[token] = inFlight.value
+		c.cachedAtByToken[token] = time.Now()
+	}
+	c.Unlock()
+	close(inFlight.done)
+
+	return inFlight.value, inFlight.err
+}
+
+func getReferenceCacheKey(clubhouseApiClient *ClubhouseApiClient, typeID string) string {
+	// Different API tokens may belong to different workspaces.
+	return clubhouseApiClient.ApiToken + "/" + typeID
+}
+
+func getCachedReference(clubhouseApiClient *ClubhouseApiClient, typeID string) (ClubhouseReference, bool) {
+	referenceCache.Lock()
+	defer referenceCache.Unlock()
+
+	cached, ok := referenceCache.referencesByKey[getReferenceCacheKey(clubhouseApiClient, typeID)]
+	if !ok || time.Since(cached.CachedAt) > clubhouseApiClient.getCacheTTL() {
+		return ClubhouseReference{}, false
+	}
+
+	return cached.Reference, true
+}
+
+func setCachedReferences(clubhouseApiClient *ClubhouseApiClient, references []ClubhouseReference) {
+	referenceCache.Lock()
+	defer referenceCache.Unlock()
+
+	now := time.Now()
+	for _, reference := range references {
+		typeID := fmt.Sprintf("%s:%d", reference.EntityType, reference.ID)
+		referenceCache.referencesByKey[getReferenceCacheKey(clubhouseApiClient, typeID)] = cachedReference{
+			Reference: reference,
+			CachedAt:  now,
+		}
+	}
+}
+
+// resolveReference finds an entity in the webhook's references, then in the cache, and then via the API.
+func resolveReference(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	entityType string,
+	id int,
+) (ClubhouseReference, error) {
+	typeID := fmt.Sprintf("%s:%d", entityType, id)
+	if reference, ok := referencesByTypeID[typeID]; ok && reference.Name != "" {
+		return reference, nil
+	}
+
+	if reference, ok := getCachedReference(clubhouseApiClient, typeID); ok {
+		return reference, nil
+	}
+
+	references, err := fetchReferences(clubhouseApiClient, entityType, id)
+	if err != nil {
+		return ClubhouseReference{}, err
+	}
+	setCachedReferences(clubhouseApiClient, references)
+
+	for _, reference := range references {
+		if reference.EntityType == entityType && reference.ID == id {
+			return reference, nil
+		}
+	}
+
+	return ClubhouseReference{}, fmt.Errorf("%s not found: %d", entityType, id)
+}
+
+// resolveReferenceName returns the name of an entity, or its ID if it cannot be resolved.
+func resolveReferenceName(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	entityType string,
+	id int,
+) string {
+	reference, err := resolveReference(clubhouseApiClient, referencesByTypeID, entityType, id)
+	if err != nil {
+		log.Printf("failed to resolve %s: %v", entityType, err)
+		return fmt.Sprintf("#%d", id)
+	}
+
+	return reference.Name
+}
+
+// fetchReferences gets an entity via the API. Endpoints which return more than the requested entity
+// (e.g. workflows, which include every state) have all of them returned, so they can be cached too.
+func fetchReferences(clubhouseApiClient *ClubhouseApiClient, entityType string, id int) ([]ClubhouseReference, error) {
+	switch entityType {
+	case "epic":
+		epic, err := clubhouseApiClient.GetEpic(id)
+		if err != nil {
+			return nil, err
+		}
+		return []ClubhouseReference{{EntityType: entityType, ID: epic.ID, Name: epic.Name, AppURL: epic.AppURL}}, nil
+	case "iteration":
+		iteration, err := clubhouseApiClient.GetIteration(id)
+		if err != nil {
+			return nil, err
+		}
+		return []ClubhouseReference{{EntityType: entityType, ID: iteration.ID, Name: iteration.Name, AppURL: iteration.AppURL}}, nil
+	case "label":
+		label, err := clubhouseApiClient.GetLabel(id)
+		if err != nil {
+			return nil, err
+		}
+		return []ClubhouseReference{{EntityType: entityType, ID: label.ID, Name: label.Name, Color: label.Color}}, nil
+	case "milestone":
+		milestone, err := clubhouseApiClient.GetMilestone(id)
+		if err != nil {
+			return nil, err
+		}
+		return []ClubhouseReference{{EntityType: entityType, ID: milestone.ID, Name: milestone.Name, AppURL: milestone.AppURL}}, nil
+	case "project":
+		project, err := clubhouseApiClient.GetProject(id)
+		if err != nil {
+			return nil, err
+		}
+		return []ClubhouseReference{{EntityType: entityType, ID: project.ID, Name: project.Name, Color: project.Color, AppURL: project.AppURL}}, nil
+	case "repository":
+		repository, err := clubhouseApiClient.GetRepository(id)
+		if err != nil {
+			return nil, err
+		}
+		return []ClubhouseReference{{EntityType: entityType, ID: repository.ID, Name: repository.Name, AppURL: repository.URL}}, nil
+	case "story":
+		story, err := clubhouseApiClient.GetStory(id)
+		if err != nil {
+			return nil, err
+		}
+		return []ClubhouseReference{{EntityType: entityType, ID: story.ID, Name: story.Name, AppURL: story.AppURL}}, nil
+	case "workflow-state":
+		workflows, err := getWorkflows(clubhouseApiClient)
+		if err != nil {
+			return nil, err
+		}
+		var references []ClubhouseReference
+		for _, workflow := range workflows {
+			for _, state := range workflow.States {
+				references = append(references, ClubhouseReference{
+					EntityType: entityType,
+					ID:         state.ID,
+					Name:       state.Name,
+					Color:      state.Color,
+					Type:       state.Type,
+				})
+			}
+		}
+		return references, nil
+	default:
+		return nil, fmt.Errorf("cannot resolve %s via the API", entityType)
+	}
+}
@@ -0,0 +1,68 @@
+package function
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkspaceCacheFetchesWithoutBlockingOtherWorkspaces(t *testing.T) {
+	cache := newWorkspaceCache()
+	slowClient := &ClubhouseApiClient{ApiToken: "slow"}
+	fastClient := &ClubhouseApiClient{ApiToken: "fast"}
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	var mutex sync.Mutex
+	slowFetches := 0
+	fetchSlow := func() (interface{}, error) {
+		mutex.Lock()
+		slowFetches++
+		mutex.Unlock()
+
+		close(entered)
+		<-release
+		return "slow workspace", nil
+	}
+
+	var wg sync.WaitGroup
+	values := make([]interface{}, 3)
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = cache.get(slowClient, fetchSlow)
+		}(i)
+		if i == 0 {
+			<-entered
+		}
+	}
+
+	// Another workspace is fetched while the slow one is still being fetched.
+	fetched := make(chan interface{}, 1)
+	go func() {
+		value, _ := cache.get(fastClient, func() (interface{}, error) { return "fast workspace", nil })
+		fetched <- value
+	}()
+	select {
+	case value := <-fetched:
+		if value != "fast workspace" {
+			t.Errorf("get() = %v, want fast workspace", value)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("get() is blocked by another workspace's fetch")
+	}
+
+	close(release)
+	wg.Wait()
+
+	// Concurrent gets for the slow workspace share its fetch.
+	for i, value := range values {
+		if value != "slow workspace" {
+			t.Errorf("get() %d = %v, want slow workspace", i, value)
+		}
+	}
+	if slowFetches != 1 {
+		t.Errorf("the slow workspace was fetched %d times, want 1", slowFetches)
+	}
+}
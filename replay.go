@@ -0,0 +1,140 @@
+package function
+
+import (
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Reasons a webhook is rejected as a replay.
+const (
+	ReplayReason_Stale     = "stale"
+	ReplayReason_Future    = "future"
+	ReplayReason_Duplicate = "duplicate"
+)
+
+// replayRejections counts rejected replays by reason. It is published with expvar (e.g. at /debug/vars
+// in the standalone server), and logged with each rejection, as expvar is not served on Cloud Functions.
+var replayRejections = expvar.NewMap("replay_rejections")
+
+// A NonceStore remembers the IDs of received webhooks, so that they are only accepted once.
+type NonceStore interface {
+	// Seen records the ID, and reports whether it had already been recorded. IDs recorded before
+	// expireBefore are forgotten, as they are rejected by the replay window anyway.
+	Seen(id string, changedAt time.Time, expireBefore time.Time) (bool, error)
+	// Forget removes a recorded ID, so that a webhook that failed to be handled is accepted when it is retried.
+	Forget(id string) error
+}
+
+// FileNonceStore stores webhook IDs in a file. To track them across multiple instances, the directory
+// must be on storage that is shared between them.
+type FileNonceStore struct {
+	Dir string
+}
+
+func (s *FileNonceStore) getPath() string {
+	return filepath.Join(s.Dir, "nonces.json")
+}
+
+func (s *FileNonceStore) read() (map[string]time.Time, error) {
+	changedAtByID := make(map[string]time.Time)
+
+	noncesData, err := ioutil.ReadFile(s.getPath())
+	if os.IsNotExist(err) {
+		return changedAtByID, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(noncesData, &changedAtByID)
+	return changedAtByID, err
+}
+
+func (s *FileNonceStore) write(changedAtByID map[string]time.Time) error {
+	noncesData, err := json.Marshal(changedAtByID)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.getPath(), noncesData)
+}
+
+func (s *FileNonceStore) Seen(id string, changedAt time.Time, expireBefore time.Time) (bool, error) {
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	changedAtByID, err := s.read()
+	if err != nil {
+		return false, err
+	}
+
+	if _, ok := changedAtByID[id]; ok {
+		return true, nil
+	}
+
+	for nonceID, nonceChangedAt := range changedAtByID {
+		if nonceChangedAt.Before(expireBefore) {
+			delete(changedAtByID, nonceID)
+		}
+	}
+	changedAtByID[id] = changedAt
+
+	return false, s.write(changedAtByID)
+}
+
+func (s *FileNonceStore) Forget(id string) error {
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	changedAtByID, err := s.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := changedAtByID[id]; !ok {
+		return nil
+	}
+
+	delete(changedAtByID, id)
+
+	return s.write(changedAtByID)
+}
+
+// checkReplay returns the reason a webhook should be rejected as a replay, or "" if it should be accepted.
+// Webhooks must have changed within the window (either side of now, to allow for clock skew), and each
+// webhook ID is only accepted once.
+func checkReplay(nonceStore NonceStore, webhook ClubhouseWebhook, window time.Duration, now time.Time) (string, error) {
+	reason := ""
+
+	switch {
+	case webhook.ChangedAt.Before(now.Add(-window)):
+		reason = ReplayReason_Stale
+	case webhook.ChangedAt.After(now.Add(window)):
+		reason = ReplayReason_Future
+	case webhook.ID != "":
+		seen, err := nonceStore.Seen(webhook.ID, webhook.ChangedAt, now.Add(-window))
+		if err != nil {
+			return "", err
+		}
+		if seen {
+			reason = ReplayReason_Duplicate
+		}
+	}
+
+	if reason != "" {
+		replayRejections.Add(reason, 1)
+		log.Printf("\nreplay_rejections: %s \n", replayRejections.String())
+	}
+
+	return reason, nil
+}
@@ -0,0 +1,128 @@
+package function
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nonceStore := &FileNonceStore{Dir: dir}
+	now := time.Now()
+	window := 5 * time.Minute
+
+	tests := []struct {
+		name      string
+		id        string
+		changedAt time.Time
+		reason    string
+	}{
+		{"first", "a", now, ""},
+		{"duplicate", "a", now, ReplayReason_Duplicate},
+		{"other", "b", now.Add(-time.Minute), ""},
+		{"stale", "c", now.Add(-window - time.Second), ReplayReason_Stale},
+		{"future", "d", now.Add(window + time.Second), ReplayReason_Future},
+		// Rejected webhooks are not recorded.
+		{"stale then current", "c", now, ""},
+		{"without an ID", "", now, ""},
+		{"without an ID again", "", now, ""},
+	}
+
+	for _, test := range tests {
+		webhook := ClubhouseWebhook{ID: test.id, ChangedAt: test.changedAt}
+		reason, err := checkReplay(nonceStore, webhook, window, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason != test.reason {
+			t.Errorf("%s: checkReplay() = %q, want %q", test.name, reason, test.reason)
+		}
+	}
+}
+
+func TestFileNonceStoreForget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	nonceStore := &FileNonceStore{Dir: dir}
+	now := time.Now()
+
+	if seen, err := nonceStore.Seen("a", now, now.Add(-time.Minute)); err != nil || seen {
+		t.Fatalf("Seen() = %t, %v, want a new ID", seen, err)
+	}
+	if err := nonceStore.Forget("a"); err != nil {
+		t.Fatal(err)
+	}
+	if seen, err := nonceStore.Seen("a", now, now.Add(-time.Minute)); err != nil || seen {
+		t.Errorf("Seen() after Forget() = %t, %v, want a new ID", seen, err)
+	}
+	if seen, err := nonceStore.Seen("a", now, now.Add(-time.Minute)); err != nil || !seen {
+		t.Errorf("Seen() again = %t, %v, want a seen ID", seen, err)
+	}
+
+	// IDs older than the window are forgotten when others are recorded.
+	if seen, err := nonceStore.Seen("b", now.Add(time.Hour), now.Add(time.Minute)); err != nil || seen {
+		t.Fatalf("Seen() = %t, %v, want a new ID", seen, err)
+	}
+	if seen, err := nonceStore.Seen("a", now, now.Add(-time.Minute)); err != nil || seen {
+		t.Errorf("Seen() after expiring = %t, %v, want a new ID", seen, err)
+	}
+}
+
+type roundTripperFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestFAcceptsRetriesOfFailedWebhooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := newTestConfig()
+	config.Replay = StoreConfig{Window: "5m", StoreDir: dir}
+	defer useTestConfig(&config)()
+
+	discordStatusCode := http.StatusBadRequest
+	http.DefaultClient.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: discordStatusCode, Body: ioutil.NopCloser(strings.NewReader("")), Request: r}, nil
+	})
+	defer func() { http.DefaultClient.Transport = nil }()
+
+	data := fmt.Sprintf(`{
+		"id": "webhook-1",
+		"changed_at": %q,
+		"version": "v1",
+		"actions": [{"id": 1, "entity_type": "story", "action": "delete", "name": "Fix the login page", "app_url": "https://app.clubhouse.io/workspace/story/1"}]
+	}`, time.Now().UTC().Format(time.RFC3339))
+
+	for _, want := range []int{http.StatusBadGateway, http.StatusOK, http.StatusConflict} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(data))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+
+		F(w, r)
+
+		if w.Code != want {
+			t.Fatalf("F() = %d %q, want %d", w.Code, w.Body.String(), want)
+		}
+		discordStatusCode = http.StatusNoContent
+	}
+}
@@ -0,0 +1,369 @@
+package function
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSecretNotFound is returned by a SecretsProvider that does not have the secret.
+var ErrSecretNotFound = errors.New("secret not found")
+
+// A SecretsProvider looks up secrets (e.g. "CLUBHOUSE_API_TOKEN") by name.
+type SecretsProvider interface {
+	GetSecret(name string) (string, error)
+}
+
+// EnvSecretsProvider reads secrets from environment variables of the same name.
+type EnvSecretsProvider struct{}
+
+func (p *EnvSecretsProvider) GetSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return value, nil
+}
+
+// FileSecretsProvider reads secrets from files named after them in a directory (e.g. a mounted Kubernetes secret).
+// Files are read again when they change, so rotated secrets are picked up without a restart.
+type FileSecretsProvider struct {
+	Dir string
+
+	mutex   sync.Mutex
+	entries map[string]fileSecretEntry
+}
+
+type fileSecretEntry struct {
+	ModTime time.Time
+	Value   string
+}
+
+func (p *FileSecretsProvider) GetSecret(name string) (string, error) {
+	secretPath := filepath.Join(p.Dir, name)
+
+	info, err := os.Stat(secretPath)
+	if os.IsNotExist(err) {
+		return "", ErrSecretNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if entry, ok := p.entries[name]; ok && entry.ModTime.Equal(info.ModTime()) {
+		return entry.Value, nil
+	}
+
+	data, err := ioutil.ReadFile(secretPath)
+	if err != nil {
+		return "", err
+	}
+
+	if p.entries == nil {
+		p.entries = make(map[string]fileSecretEntry)
+	}
+	value := strings.TrimSpace(string(data))
+	p.entries[name] = fileSecretEntry{ModTime: info.ModTime(), Value: value}
+
+	return value, nil
+}
+
+// JSONFileSecretsProvider reads secrets from a JSON object in a file, e.g. {"CLUBHOUSE_API_TOKEN": "..."}.
+// It stands in for a secret manager when developing and testing locally.
+type JSONFileSecretsProvider struct {
+	Path string
+}
+
+func (p *JSONFileSecretsProvider) GetSecret(name string) (string, error) {
+	data, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return "", err
+	}
+
+	var secrets map[string]string
+	if err := json.Unmarshal(data, &secrets); err != nil {
+		return "", err
+	}
+
+	value, ok := secrets[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return value, nil
+}
+
+// GCPSecretManagerProvider reads the latest version of secrets from Google Cloud Secret Manager, authenticating
+// as the default service account of the instance (e.g. the Cloud Function's).
+// https://cloud.google.com/secret-manager/docs/reference/rest/v1/projects.secrets.versions/access
+type GCPSecretManagerProvider struct {
+	Project string
+}
+
+func (p *GCPSecretManagerProvider) GetSecret(name string) (string, error) {
+	accessToken, err := getGCPAccessToken()
+	if err != nil {
+		return "", err
+	}
+
+	secretURL := fmt.Sprintf(
+		"https://secretmanager.googleapis.com/v1/projects/%s/secrets/%s/versions/latest:access",
+		url.PathEscape(p.Project),
+		url.PathEscape(name),
+	)
+
+	var secretRes struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	err = getSecretJSON(secretURL, map[string]string{"Authorization": "Bearer " + accessToken}, &secretRes)
+	if err != nil {
+		return "", err
+	}
+
+	value, err := base64.StdEncoding.DecodeString(secretRes.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+
+	return string(value), nil
+}
+
+// https://cloud.google.com/compute/docs/access/create-enable-service-accounts-for-instances#applications
+func getGCPAccessToken() (string, error) {
+	var tokenRes struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := getSecretJSON(
+		"http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token",
+		map[string]string{"Metadata-Flavor": "Google"},
+		&tokenRes,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return tokenRes.AccessToken, nil
+}
+
+// VaultSecretsProvider reads secrets from the keys of a HashiCorp Vault KV version 2 secret.
+// https://www.vaultproject.io/api-docs/secret/kv/kv-v2#read-secret-version
+type VaultSecretsProvider struct {
+	Address string
+	Token   string
+	// e.g. "secret/data/clubhouse-to-discord".
+	Path string
+}
+
+func (p *VaultSecretsProvider) GetSecret(name string) (string, error) {
+	secretURL := strings.TrimSuffix(p.Address, "/") + "/v1/" + strings.TrimPrefix(p.Path, "/")
+
+	var secretRes struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	err := getSecretJSON(secretURL, map[string]string{"X-Vault-Token": p.Token}, &secretRes)
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := secretRes.Data.Data[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return value, nil
+}
+
+func getSecretJSON(secretURL string, headers map[string]string, result interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, secretURL, nil)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return ErrSecretNotFound
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		// The body is not included, as it may contain part of a secret.
+		return fmt.Errorf("failed to get secret (status code: %d)", res.StatusCode)
+	}
+
+	return json.Unmarshal(data, result)
+}
+
+// cachingSecretsProvider remembers secrets for a while, so that remote providers are not called for every webhook.
+type cachingSecretsProvider struct {
+	Provider SecretsProvider
+	TTL      time.Duration
+
+	mutex   sync.Mutex
+	entries map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	Value    string
+	Err      error
+	CachedAt time.Time
+}
+
+func (p *cachingSecretsProvider) GetSecret(name string) (string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if entry, ok := p.entries[name]; ok && time.Since(entry.CachedAt) <= p.TTL {
+		return entry.Value, entry.Err
+	}
+
+	value, err := p.Provider.GetSecret(name)
+	if err != nil && err != ErrSecretNotFound {
+		// Other errors may be temporary, so they are not cached.
+		return "", err
+	}
+
+	if p.entries == nil {
+		p.entries = make(map[string]cachedSecret)
+	}
+	p.entries[name] = cachedSecret{Value: value, Err: err, CachedAt: time.Now()}
+
+	return value, err
+}
+
+// Providers are kept by configuration, as tenants may each have their own.
+var secretsProviderCache = struct {
+	sync.Mutex
+	providersByConfig map[SecretsConfig]SecretsProvider
+}{
+	providersByConfig: make(map[SecretsConfig]SecretsProvider),
+}
+
+// getSecretsCacheTTL returns how long secrets are cached for, 5 minutes by default.
+func getSecretsCacheTTL(config SecretsConfig) (time.Duration, error) {
+	if config.CacheTTL == "" {
+		return 5 * time.Minute, nil
+	}
+
+	cacheTTL, err := time.ParseDuration(config.CacheTTL)
+	if err != nil || cacheTTL < 0 {
+		return 0, fmt.Errorf("secrets.cache_ttl (SECRETS_CACHE_TTL): must be a duration, got %q", config.CacheTTL)
+	}
+
+	return cacheTTL, nil
+}
+
+// getSecretsProvider returns the configured provider ("env", "file", "json", "gcp" or "vault").
+// The provider is kept between calls, so that its cache is too.
+func getSecretsProvider(config SecretsConfig) (SecretsProvider, error) {
+	secretsProviderCache.Lock()
+	defer secretsProviderCache.Unlock()
+
+	if provider, ok := secretsProviderCache.providersByConfig[config]; ok {
+		return provider, nil
+	}
+
+	cacheTTL, err := getSecretsCacheTTL(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var provider SecretsProvider
+	switch config.Provider {
+	case "", "env":
+		provider = &EnvSecretsProvider{}
+	case "file":
+		if config.Dir == "" {
+			return nil, errors.New("secrets.dir (SECRETS_DIR): is required by the file provider")
+		}
+		provider = &FileSecretsProvider{Dir: config.Dir}
+	case "json":
+		if config.File == "" {
+			return nil, errors.New("secrets.file (SECRETS_FILE): is required by the json provider")
+		}
+		provider = &JSONFileSecretsProvider{Path: config.File}
+	case "gcp":
+		if config.GCPProject == "" {
+			return nil, errors.New("secrets.gcp_project (GCP_PROJECT): is required by the gcp provider")
+		}
+		provider = &cachingSecretsProvider{Provider: &GCPSecretManagerProvider{Project: config.GCPProject}, TTL: cacheTTL}
+	case "vault":
+		if config.VaultAddr == "" || config.VaultToken == "" || config.VaultSecretPath == "" {
+			return nil, errors.New("secrets.vault_addr, vault_token and vault_secret_path (VAULT_ADDR, VAULT_TOKEN and VAULT_SECRET_PATH): are required by the vault provider")
+		}
+		provider = &cachingSecretsProvider{
+			Provider: &VaultSecretsProvider{
+				Address: config.VaultAddr,
+				Token:   config.VaultToken,
+				Path:    config.VaultSecretPath,
+			},
+			TTL: cacheTTL,
+		}
+	default:
+		return nil, fmt.Errorf("secrets.provider (SECRETS_PROVIDER): must be one of env, file, json, gcp or vault, got %q", config.Provider)
+	}
+
+	secretsProviderCache.providersByConfig[config] = provider
+
+	return provider, nil
+}
+
+// getSecret reads a secret from the provider, falling back to the configured value (e.g. the environment
+// variable of the same name), so that secrets can be moved to the provider one at a time.
+func getSecret(provider SecretsProvider, name string, fallback string) (string, error) {
+	value, err := provider.GetSecret(name)
+	if err == ErrSecretNotFound {
+		return fallback, nil
+	}
+
+	return value, err
+}
+
+// secretReferencePrefix marks a configured value that is the name of a secret, e.g. "secret://SLACK_WEBHOOK_URL".
+const secretReferencePrefix = "secret://"
+
+// resolveSecretReference reads the secret a "secret://NAME" value refers to. Other values are returned as they are.
+func resolveSecretReference(provider SecretsProvider, value string) (string, error) {
+	if !strings.HasPrefix(value, secretReferencePrefix) {
+		return value, nil
+	}
+
+	name := strings.TrimPrefix(value, secretReferencePrefix)
+	if name == "" {
+		return "", errors.New("must name a secret, e.g. secret://SLACK_WEBHOOK_URL")
+	}
+
+	secret, err := provider.GetSecret(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to get `%s`: %v", name, err)
+	}
+
+	return secret, nil
+}
@@ -0,0 +1,317 @@
+package function
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSecretsProvider struct {
+	secrets map[string]string
+	err     error
+	calls   int
+}
+
+func (p *fakeSecretsProvider) GetSecret(name string) (string, error) {
+	p.calls++
+	if p.err != nil {
+		return "", p.err
+	}
+
+	value, ok := p.secrets[name]
+	if !ok {
+		return "", ErrSecretNotFound
+	}
+
+	return value, nil
+}
+
+// useFakeSecretsProvider makes configurations with these secrets settings use a fake provider.
+func useFakeSecretsProvider(config SecretsConfig, provider SecretsProvider) func() {
+	secretsProviderCache.Lock()
+	defer secretsProviderCache.Unlock()
+
+	secretsProviderCache.providersByConfig[config] = provider
+
+	return func() {
+		secretsProviderCache.Lock()
+		defer secretsProviderCache.Unlock()
+
+		delete(secretsProviderCache.providersByConfig, config)
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	secretsConfig := SecretsConfig{Provider: "fake", Dir: t.Name()}
+	defer useFakeSecretsProvider(secretsConfig, &fakeSecretsProvider{secrets: map[string]string{
+		"CLUBHOUSE_API_TOKEN":           "top level token",
+		"ACME_CLUBHOUSE_API_TOKEN":      "acme token",
+		"ACME_CLUBHOUSE_WEBHOOK_SECRET": "new, old",
+		"ACME_TENANT_TOKEN":             "acme tenant token",
+	}})()
+
+	config := newTestConfig()
+	config.Secrets = secretsConfig
+
+	resolvedConfig, err := config.resolveSecrets("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolvedConfig.Clubhouse.ApiToken != "top level token" {
+		t.Errorf("ApiToken = %q, want the provider's", resolvedConfig.Clubhouse.ApiToken)
+	}
+	// Secrets the provider does not have keep their configured values.
+	if resolvedConfig.Discord.WebhookURL != config.Discord.WebhookURL {
+		t.Errorf("WebhookURL = %q, want the configured one", resolvedConfig.Discord.WebhookURL)
+	}
+
+	resolvedConfig, err = config.resolveSecrets("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolvedConfig.Clubhouse.ApiToken != "acme token" || resolvedConfig.Token != "acme tenant token" {
+		t.Errorf("tenant secrets = %q, %q, want the prefixed ones", resolvedConfig.Clubhouse.ApiToken, resolvedConfig.Token)
+	}
+	if secrets := resolvedConfig.Clubhouse.WebhookSecrets; len(secrets) != 2 || secrets[0] != "new" || secrets[1] != "old" {
+		t.Errorf("WebhookSecrets = %q, want [new old]", secrets)
+	}
+}
+
+func TestResolveSecretsErrors(t *testing.T) {
+	secretsConfig := SecretsConfig{Provider: "fake", Dir: t.Name()}
+	defer useFakeSecretsProvider(secretsConfig, &fakeSecretsProvider{err: errors.New("unavailable")})()
+
+	config := newTestConfig()
+	config.Secrets = secretsConfig
+
+	if _, err := config.resolveSecrets(""); err == nil {
+		t.Error("resolveSecrets() = nil, want the provider's error")
+	}
+}
+
+func TestGetSecretsProviderIsKeptByConfig(t *testing.T) {
+	fileConfig := SecretsConfig{Provider: "file", Dir: "/run/secrets"}
+	jsonConfig := SecretsConfig{Provider: "json", File: "/run/secrets.json"}
+
+	fileProvider, err := getSecretsProvider(fileConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonProvider, err := getSecretsProvider(jsonConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if provider, _ := getSecretsProvider(fileConfig); provider != fileProvider {
+		t.Error("the file provider was created again after another provider was used")
+	}
+	if provider, _ := getSecretsProvider(jsonConfig); provider != jsonProvider {
+		t.Error("the json provider was created again after another provider was used")
+	}
+}
+
+func TestCachingSecretsProvider(t *testing.T) {
+	fakeProvider := &fakeSecretsProvider{secrets: map[string]string{"CLUBHOUSE_API_TOKEN": "token"}}
+	provider := &cachingSecretsProvider{Provider: fakeProvider, TTL: time.Hour}
+
+	for i := 0; i < 2; i++ {
+		if value, err := provider.GetSecret("CLUBHOUSE_API_TOKEN"); err != nil || value != "token" {
+			t.Fatalf("GetSecret() = %q, %v", value, err)
+		}
+		if _, err := provider.GetSecret("MISSING"); err != ErrSecretNotFound {
+			t.Fatalf("GetSecret() = %v, want ErrSecretNotFound", err)
+		}
+	}
+	if fakeProvider.calls != 2 {
+		t.Errorf("the provider was called %d times, want 2", fakeProvider.calls)
+	}
+
+	// Other errors may be temporary, so they are not cached.
+	fakeProvider.err = errors.New("unavailable")
+	for i := 0; i < 2; i++ {
+		if _, err := provider.GetSecret("OTHER"); err == nil {
+			t.Fatal("GetSecret() = nil, want the provider's error")
+		}
+	}
+	if fakeProvider.calls != 4 {
+		t.Errorf("the provider was called %d times, want 4", fakeProvider.calls)
+	}
+}
+
+func TestResolveSinkSecretReferences(t *testing.T) {
+	secretsConfig := SecretsConfig{Provider: "fake", Dir: t.Name()}
+	defer useFakeSecretsProvider(secretsConfig, &fakeSecretsProvider{secrets: map[string]string{
+		"SLACK_WEBHOOK_URL": "https://hooks.slack.com/services/T000/B000/XXXX",
+		"SMTP_PASSWORD":     "hunter2",
+		"SIGNING_SECRET":    "signing secret",
+		"MATRIX_TOKEN":      "matrix token",
+	}})()
+
+	config := newTestConfig()
+	config.Secrets = secretsConfig
+	config.Sinks = []SinkConfig{
+		{Type: "slack", WebhookURL: "secret://SLACK_WEBHOOK_URL"},
+		{Type: "email", SMTPPassword: "secret://SMTP_PASSWORD"},
+		{Type: "webhook", WebhookURL: "https://example.com/hook", Secret: "secret://SIGNING_SECRET"},
+		{Type: "matrix", AccessToken: "secret://MATRIX_TOKEN"},
+	}
+
+	resolvedConfig, err := config.resolveSecrets("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []SinkConfig{
+		{Type: "slack", WebhookURL: "https://hooks.slack.com/services/T000/B000/XXXX"},
+		{Type: "email", SMTPPassword: "hunter2"},
+		{Type: "webhook", WebhookURL: "https://example.com/hook", Secret: "signing secret"},
+		{Type: "matrix", AccessToken: "matrix token"},
+	}
+	for i := range want {
+		if resolvedConfig.Sinks[i].WebhookURL != want[i].WebhookURL ||
+			resolvedConfig.Sinks[i].Secret != want[i].Secret ||
+			resolvedConfig.Sinks[i].SMTPPassword != want[i].SMTPPassword ||
+			resolvedConfig.Sinks[i].AccessToken != want[i].AccessToken {
+			t.Errorf("sinks[%d] = %+v, want %+v", i, resolvedConfig.Sinks[i], want[i])
+		}
+	}
+
+	// The configuration keeps its references, so that rotated secrets are read again.
+	if config.Sinks[0].WebhookURL != "secret://SLACK_WEBHOOK_URL" {
+		t.Errorf("the configuration was changed: %+v", config.Sinks[0])
+	}
+
+	// Referenced secrets must exist.
+	config.Sinks = []SinkConfig{{Type: "slack", WebhookURL: "secret://MISSING"}}
+	if _, err := config.resolveSecrets(""); err == nil || !strings.Contains(err.Error(), "sinks[0].webhook_url (SINKS)") {
+		t.Errorf("resolveSecrets() = %v, want an error for the missing secret", err)
+	}
+}
+
+func TestResolvedSecretsAreCached(t *testing.T) {
+	fakeProvider := &fakeSecretsProvider{secrets: map[string]string{"CLUBHOUSE_API_TOKEN": "old token"}}
+	secretsConfig := SecretsConfig{Provider: "fake", Dir: t.Name(), CacheTTL: "1h"}
+	defer useFakeSecretsProvider(secretsConfig, fakeProvider)()
+
+	config := newTestConfig()
+	config.Secrets = secretsConfig
+	defer useTestConfig(&config)()
+
+	for i := 0; i < 3; i++ {
+		env, err := getEnvironment()
+		if err != nil {
+			t.Fatalf("getEnvironment() = %v", err)
+		}
+		if env.ClubhouseApiToken != "old token" {
+			t.Fatalf("ClubhouseApiToken = %q, want old token", env.ClubhouseApiToken)
+		}
+	}
+	callsPerResolve := fakeProvider.calls
+
+	// Rotated secrets are picked up once the cached ones expire.
+	fakeProvider.secrets["CLUBHOUSE_API_TOKEN"] = "new token"
+	environmentCache.Lock()
+	cached := environmentCache.envsByTenant[""]
+	cached.resolvedAt = time.Now().Add(-2 * time.Hour)
+	environmentCache.envsByTenant[""] = cached
+	environmentCache.Unlock()
+
+	if env, _ := getEnvironment(); env.ClubhouseApiToken != "new token" {
+		t.Errorf("ClubhouseApiToken = %q, want new token", env.ClubhouseApiToken)
+	}
+	if fakeProvider.calls != 2*callsPerResolve {
+		t.Errorf("the provider was called %d times, want %d", fakeProvider.calls, 2*callsPerResolve)
+	}
+}
+
+func TestPreviousSecretsAreUsedWhenTheProviderFails(t *testing.T) {
+	fakeProvider := &fakeSecretsProvider{secrets: map[string]string{"CLUBHOUSE_API_TOKEN": "old token"}}
+	secretsConfig := SecretsConfig{Provider: "fake", Dir: t.Name(), CacheTTL: "1h"}
+	defer useFakeSecretsProvider(secretsConfig, fakeProvider)()
+
+	config := newTestConfig()
+	config.Secrets = secretsConfig
+	defer useTestConfig(&config)()
+
+	if _, err := getEnvironment(); err != nil {
+		t.Fatalf("getEnvironment() = %v", err)
+	}
+
+	fakeProvider.err = errors.New("unavailable")
+	environmentCache.Lock()
+	cached := environmentCache.envsByTenant[""]
+	cached.resolvedAt = time.Now().Add(-2 * time.Hour)
+	environmentCache.envsByTenant[""] = cached
+	environmentCache.Unlock()
+
+	env, err := getEnvironment()
+	if err != nil {
+		t.Fatalf("getEnvironment() = %v, want the previous settings", err)
+	}
+	if env.ClubhouseApiToken != "old token" {
+		t.Errorf("ClubhouseApiToken = %q, want old token", env.ClubhouseApiToken)
+	}
+
+	// Without previous settings, the error is returned rather than exiting.
+	environmentCache.Lock()
+	environmentCache.envsByTenant = make(map[string]cachedEnvironment)
+	environmentCache.Unlock()
+
+	if _, err := getEnvironment(); err == nil {
+		t.Error("getEnvironment() = nil, want the provider's error")
+	}
+}
+
+// blockingSecretsProvider waits to be released before returning its secrets.
+type blockingSecretsProvider struct {
+	entered chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (p *blockingSecretsProvider) GetSecret(name string) (string, error) {
+	p.once.Do(func() { close(p.entered) })
+	<-p.release
+	return "", ErrSecretNotFound
+}
+
+func TestSecretsAreReadWithoutBlockingOtherTenants(t *testing.T) {
+	blockingProvider := &blockingSecretsProvider{entered: make(chan struct{}), release: make(chan struct{})}
+	secretsConfig := SecretsConfig{Provider: "fake", Dir: t.Name()}
+	defer useFakeSecretsProvider(secretsConfig, blockingProvider)()
+
+	slowTenant := newTestConfig()
+	slowTenant.Secrets = secretsConfig
+	config := newTestConfig()
+	config.Tenants = map[string]Config{"slow": slowTenant}
+	defer useTestConfig(&config)()
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := getTenantEnvironment("slow")
+		done <- err
+	}()
+	<-blockingProvider.entered
+
+	// The top level settings are loaded while the slow tenant's secrets are still being read.
+	loaded := make(chan error, 1)
+	go func() {
+		_, err := getEnvironment()
+		loaded <- err
+	}()
+	select {
+	case err := <-loaded:
+		if err != nil {
+			t.Errorf("getEnvironment() = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("getEnvironment() is blocked by another tenant's secrets")
+	}
+
+	close(blockingProvider.release)
+	if err := <-done; err != nil {
+		t.Errorf("getTenantEnvironment() = %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package function
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// parseWebhookSecrets splits a comma separated list of secrets, so that a new secret can be added
+// before the old one is removed when rotating it.
+func parseWebhookSecrets(rawSecrets string) []string {
+	var secrets []string
+	for _, secret := range strings.Split(rawSecrets, ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	return secrets
+}
+
+// verifyClubhouseSignature reports whether the hex encoded signature is the HMAC-SHA256 of data with any of the secrets.
+// Every secret is checked, and malformed signatures are compared like any other, so that the time taken does not
+// reveal which part of the signature was wrong.
+func verifyClubhouseSignature(secrets []string, signature string, data []byte) bool {
+	signatureMAC, err := hex.DecodeString(strings.TrimSpace(signature))
+	malformed := err != nil || len(signatureMAC) != sha256.Size
+	if malformed {
+		signatureMAC = make([]byte, sha256.Size)
+	}
+
+	verified := false
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		_, _ = mac.Write(data)
+		if hmac.Equal(signatureMAC, mac.Sum(nil)) {
+			verified = true
+		}
+	}
+
+	return verified && !malformed
+}
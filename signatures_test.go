@@ -0,0 +1,94 @@
+package function
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func signClubhouseWebhook(secret string, data []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestParseWebhookSecrets(t *testing.T) {
+	if secrets := parseWebhookSecrets(" new, old ,,"); !reflect.DeepEqual(secrets, []string{"new", "old"}) {
+		t.Errorf("parseWebhookSecrets() = %q, want [new old]", secrets)
+	}
+	if secrets := parseWebhookSecrets(""); secrets != nil {
+		t.Errorf("parseWebhookSecrets(\"\") = %q, want none", secrets)
+	}
+}
+
+func TestVerifyClubhouseSignature(t *testing.T) {
+	data := []byte(`{"id": "1"}`)
+
+	tests := []struct {
+		name      string
+		secrets   []string
+		signature string
+		verified  bool
+	}{
+		{"signed", []string{"secret"}, signClubhouseWebhook("secret", data), true},
+		{"signed with the old secret", []string{"new", "old"}, signClubhouseWebhook("old", data), true},
+		{"signed with the new secret", []string{"new", "old"}, signClubhouseWebhook("new", data), true},
+		{"padded", []string{"secret"}, " " + signClubhouseWebhook("secret", data) + " ", true},
+		{"wrong secret", []string{"secret"}, signClubhouseWebhook("other", data), false},
+		{"other data", []string{"secret"}, signClubhouseWebhook("secret", []byte(`{"id": "2"}`)), false},
+		{"not hex", []string{"secret"}, "not a signature", false},
+		{"truncated", []string{"secret"}, signClubhouseWebhook("secret", data)[:32], false},
+		{"no secrets", nil, signClubhouseWebhook("secret", data), false},
+	}
+
+	for _, test := range tests {
+		if verified := verifyClubhouseSignature(test.secrets, test.signature, data); verified != test.verified {
+			t.Errorf("%s: verifyClubhouseSignature() = %t, want %t", test.name, verified, test.verified)
+		}
+	}
+}
+
+func TestFRejectsInvalidRequests(t *testing.T) {
+	data := []byte(`{"id": "1", "version": "v1", "actions": []}`)
+
+	tests := []struct {
+		name       string
+		secrets    []string
+		signature  string
+		body       []byte
+		statusCode int
+	}{
+		{"signed", []string{"secret"}, signClubhouseWebhook("secret", data), data, http.StatusOK},
+		{"wrong signature", []string{"secret"}, signClubhouseWebhook("other", data), data, http.StatusUnauthorized},
+		{"unsigned", []string{"secret"}, "", data, http.StatusUnauthorized},
+		{"signed without a secret", nil, signClubhouseWebhook("secret", data), data, http.StatusInternalServerError},
+		{"unsigned without a secret", nil, "", data, http.StatusOK},
+		{"invalid JSON", nil, "", []byte(`{`), http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := newTestConfig()
+			config.Clubhouse.WebhookSecrets = test.secrets
+			defer useTestConfig(&config)()
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(string(test.body)))
+			r.Header.Set("Content-Type", "application/json")
+			if test.signature != "" {
+				r.Header.Set("Clubhouse-Signature", test.signature)
+			}
+			w := httptest.NewRecorder()
+
+			F(w, r)
+
+			if w.Code != test.statusCode {
+				t.Errorf("F() = %d %q, want %d", w.Code, w.Body.String(), test.statusCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// A Sink posts events somewhere, e.g. a Discord or Slack channel.
+type Sink interface {
+	// Name identifies the sink in logs.
+	Name() string
+	// Send posts an event, giving up when the context is done.
+	Send(ctx context.Context, event Event) error
+}
+
+type SinkConfig struct {
+	// Identifies the sink in logs. Defaults to the type.
+	Name string `json:"name,omitempty"`
+	// "discord", "slack", "teams", "matrix", "webhook" or "email".
+	Type string `json:"type"`
+	// Used by discord, slack, teams and webhook.
+	WebhookURL string `json:"webhook_url,omitempty"`
+	// Used by webhook, to sign requests.
+	Secret string `json:"secret,omitempty"`
+	// Used by email. SMTPHost is a host and port, e.g. smtp.example.com:587.
+	SMTPHost     string       `json:"smtp_host,omitempty"`
+	SMTPUsername string       `json:"smtp_username,omitempty"`
+	SMTPPassword string       `json:"smtp_password,omitempty"`
+	From         string       `json:"from,omitempty"`
+	To           []string     `json:"to,omitempty"`
+	Routes       []EmailRoute `json:"routes,omitempty"`
+	// A duration, e.g. "15m".
+	BatchWindow string `json:"batch_window,omitempty"`
+
+	// How long each attempt may take (e.g. "10s"), how many times failed attempts are retried, and how long
+	// to wait before the first retry (e.g. "1s", doubled for each retry after it).
+	Timeout      string `json:"timeout,omitempty"`
+	Retries      *int   `json:"retries,omitempty"`
+	RetryBackoff string `json:"retry_backoff,omitempty"`
+	// Used by matrix.
+	Homeserver  string `json:"homeserver,omitempty"`
+	RoomID      string `json:"room_id,omitempty"`
+	AccessToken string `json:"access_token,omitempty"`
+}
+
+// A BatchingSink holds events back to send them together, and needs to be flushed periodically.
+type BatchingSink interface {
+	Sink
+	// Flush sends the held events, if they are due (or force is set).
+	Flush(ctx context.Context, now time.Time, force bool) error
+}
+
+// newSink creates the sink described by a configuration, checking that its settings are valid. Sinks that
+// batch events keep them in storeDir.
+func newSink(config SinkConfig, storeDir string) (Sink, error) {
+	name := config.Name
+	if name == "" {
+		name = config.Type
+	}
+
+	switch config.Type {
+	case "discord":
+		if err := validateDiscordWebhookURL(config.WebhookURL); err != nil {
+			return nil, fmt.Errorf("webhook_url: %v", err)
+		}
+		return &DiscordSink{SinkName: name, WebhookURL: config.WebhookURL}, nil
+	case "slack":
+		if err := validateSlackWebhookURL(config.WebhookURL); err != nil {
+			return nil, fmt.Errorf("webhook_url: %v", err)
+		}
+		return &SlackSink{SinkName: name, WebhookURL: config.WebhookURL}, nil
+	case "teams":
+		if err := validateTeamsWebhookURL(config.WebhookURL); err != nil {
+			return nil, fmt.Errorf("webhook_url: %v", err)
+		}
+		return &TeamsSink{SinkName: name, WebhookURL: config.WebhookURL}, nil
+	case "matrix":
+		if err := validateMatrixSinkConfig(config); err != nil {
+			return nil, err
+		}
+		return &MatrixSink{
+			SinkName:    name,
+			Homeserver:  config.Homeserver,
+			RoomID:      config.RoomID,
+			AccessToken: config.AccessToken,
+		}, nil
+	case "webhook":
+		if err := validateWebhookSinkURL(config.WebhookURL); err != nil {
+			return nil, fmt.Errorf("webhook_url: %v", err)
+		}
+		return &WebhookSink{SinkName: name, URL: config.WebhookURL, Secret: config.Secret}, nil
+	case "email":
+		if err := validateEmailSinkConfig(config); err != nil {
+			return nil, err
+		}
+		batchWindow, _ := time.ParseDuration(config.BatchWindow)
+		return &EmailSink{
+			SinkName:     name,
+			SMTPHost:     config.SMTPHost,
+			SMTPUsername: config.SMTPUsername,
+			SMTPPassword: config.SMTPPassword,
+			From:         config.From,
+			To:           config.To,
+			Routes:       config.Routes,
+			BatchWindow:  batchWindow,
+			BatchDir:     storeDir,
+		}, nil
+	default:
+		return nil, fmt.Errorf("type: must be one of discord, slack, teams, matrix, webhook or email, got %q", config.Type)
+	}
+}
+
+// needsNormalizedEvent reports whether any of the sinks posts normalized events, which take extra API requests to build.
+func needsNormalizedEvent(sinks []ConfiguredSink) bool {
+	for _, sink := range sinks {
+		if _, ok := sink.Sink.(*WebhookSink); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FlushSinks sends the events held back by batching sinks that are due.
+func FlushSinks() {
+	for _, env := range getEnvironments() {
+		for _, sink := range env.Sinks {
+			batchingSink, ok := sink.Sink.(BatchingSink)
+			if !ok {
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), sink.Policy.Timeout)
+			if err := batchingSink.Flush(ctx, time.Now(), false); err != nil {
+				log.Println("failed to flush sink", sink.Sink.Name()+":", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// postJSON posts a payload to a webhook, and returns a SinkStatusError for responses with a non-2xx status code.
+func postJSON(ctx context.Context, webhookURL string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return postJSONWithHeaders(ctx, webhookURL, data, nil)
+}
+
+func postJSONWithHeaders(ctx context.Context, webhookURL string, data []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhookURL, bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		log.Println("payload", string(data))
+		return &SinkStatusError{StatusCode: res.StatusCode}
+	}
+
+	return nil
+}
+
+// DiscordSink posts events to a Discord webhook.
+type DiscordSink struct {
+	SinkName   string
+	WebhookURL string
+}
+
+func (s *DiscordSink) Name() string {
+	return s.SinkName
+}
+
+func (s *DiscordSink) Send(ctx context.Context, event Event) error {
+	return postJSON(ctx, s.WebhookURL, toDiscordWebhook(event))
+}
+
+func toDiscordWebhook(event Event) DiscordWebhook {
+	discordWebhook := DiscordWebhook{Content: toDiscordMarkdown(event.Content)}
+
+	for _, item := range event.Items {
+		embed := Embed{
+			Title:       item.Title,
+			URL:         item.URL,
+			Description: item.Description,
+			Color:       item.Colour,
+		}
+		for _, field := range item.Fields {
+			embed.Fields = append(embed.Fields, Field{
+				Name:   field.Name,
+				Value:  truncateText(toDiscordMarkdown(field.Value), maxFieldValueLength),
+				Inline: field.Inline,
+			})
+		}
+		discordWebhook.Embeds = append(discordWebhook.Embeds, embed)
+	}
+
+	return discordWebhook
+}
+
+// toDiscordMarkdown writes text in Discord's markdown, with timestamps shown in each viewer's timezone.
+// https://discord.com/developers/docs/reference#message-formatting
+func toDiscordMarkdown(text Text) string {
+	return text.format(textFormat{
+		plain: func(text string) string { return text },
+		bold:  func(text string) string { return "**" + text + "**" },
+		code:  func(text string) string { return "`" + text + "`" },
+		link:  func(text string, url string) string { return fmt.Sprintf("[%s](%s)", text, url) },
+		time: func(kind TextSpanKind, t time.Time) string {
+			style := "D"
+			if kind == TextSpanKind_DateTime {
+				style = "f"
+			} else if kind == TextSpanKind_RelativeDate {
+				style = "R"
+			}
+			return fmt.Sprintf("<t:%d:%s>", t.Unix(), style)
+		},
+	})
+}
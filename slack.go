@@ -0,0 +1,145 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// https://api.slack.com/reference/block-kit/blocks#section
+const (
+	maxSlackSectionTextLength = 3000
+	maxSlackFieldTextLength   = 2000
+	maxSlackFieldsPerSection  = 10
+)
+
+var slackWebhookURLPattern = regexp.MustCompile(`^https://hooks\.slack\.com/services/[A-Za-z0-9]+/[A-Za-z0-9]+/[A-Za-z0-9]+$`)
+
+// https://api.slack.com/messaging/webhooks
+type SlackMessage struct {
+	Text        string            `json:"text"`
+	Blocks      []SlackBlock      `json:"blocks,omitempty"`
+	Attachments []SlackAttachment `json:"attachments,omitempty"`
+}
+
+// Attachments are only used for their coloured bar, which blocks cannot have.
+type SlackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []SlackBlock `json:"blocks"`
+}
+
+// https://api.slack.com/reference/block-kit/blocks
+type SlackBlock struct {
+	Type   string      `json:"type"`
+	Text   *SlackText  `json:"text,omitempty"`
+	Fields []SlackText `json:"fields,omitempty"`
+}
+
+// https://api.slack.com/reference/block-kit/composition-objects#text
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// SlackSink posts events to a Slack incoming webhook as Block Kit messages.
+type SlackSink struct {
+	SinkName   string
+	WebhookURL string
+}
+
+func (s *SlackSink) Name() string {
+	return s.SinkName
+}
+
+func (s *SlackSink) Send(ctx context.Context, event Event) error {
+	return postJSON(ctx, s.WebhookURL, toSlackMessage(event))
+}
+
+func validateSlackWebhookURL(webhookURL string) error {
+	if !slackWebhookURLPattern.MatchString(webhookURL) {
+		return fmt.Errorf("must match https://hooks.slack.com/services/{team}/{bot}/{token}")
+	}
+
+	return nil
+}
+
+func toSlackMessage(event Event) SlackMessage {
+	var message SlackMessage
+
+	if len(event.Content) > 0 {
+		message.Text = toSlackMarkdown(event.Content)
+		message.Blocks = append(message.Blocks, newSlackSection(message.Text))
+	}
+
+	for _, item := range event.Items {
+		title := escapeSlackText(item.Title)
+		if item.URL != "" {
+			title = fmt.Sprintf("<%s|%s>", item.URL, title)
+		}
+
+		// The text is shown in notifications.
+		if message.Text == "" {
+			message.Text = escapeSlackText(item.Title)
+		}
+
+		blocks := []SlackBlock{newSlackSection("*" + title + "*")}
+
+		if item.Description != "" {
+			blocks = append(blocks, newSlackSection(escapeSlackText(item.Description)))
+		}
+
+		var fields []SlackText
+		for _, field := range item.Fields {
+			text := fmt.Sprintf("*%s*\n%s", escapeSlackText(field.Name), toSlackMarkdown(field.Value))
+			fields = append(fields, SlackText{Type: "mrkdwn", Text: truncateText(text, maxSlackFieldTextLength)})
+		}
+		for len(fields) > 0 {
+			n := len(fields)
+			if n > maxSlackFieldsPerSection {
+				n = maxSlackFieldsPerSection
+			}
+			blocks = append(blocks, SlackBlock{Type: "section", Fields: fields[:n]})
+			fields = fields[n:]
+		}
+
+		message.Attachments = append(message.Attachments, SlackAttachment{
+			Color:  fmt.Sprintf("#%06x", item.Colour),
+			Blocks: blocks,
+		})
+	}
+
+	return message
+}
+
+func newSlackSection(text string) SlackBlock {
+	return SlackBlock{
+		Type: "section",
+		Text: &SlackText{Type: "mrkdwn", Text: truncateText(text, maxSlackSectionTextLength)},
+	}
+}
+
+// escapeSlackText escapes the characters Slack uses for its own markup.
+// https://api.slack.com/reference/surfaces/formatting#escaping
+func escapeSlackText(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+// toSlackMarkdown writes text in Slack's mrkdwn. Slack has no relative dates, so those are shown as dates too.
+// https://api.slack.com/reference/surfaces/formatting
+func toSlackMarkdown(text Text) string {
+	return text.format(textFormat{
+		plain: escapeSlackText,
+		bold:  func(text string) string { return "*" + escapeSlackText(text) + "*" },
+		code:  func(text string) string { return "`" + escapeSlackText(text) + "`" },
+		link:  func(text string, url string) string { return fmt.Sprintf("<%s|%s>", url, escapeSlackText(text)) },
+		time: func(kind TextSpanKind, t time.Time) string {
+			format := "{date_short_pretty}"
+			if kind == TextSpanKind_DateTime {
+				format = "{date_short_pretty} {time}"
+			}
+			return fmt.Sprintf("<!date^%d^%s|%s>", t.Unix(), format, formatPlainTime(kind, t))
+		},
+	})
+}
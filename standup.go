@@ -0,0 +1,356 @@
+package function
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type standupReport struct {
+	MemberName    string
+	DoneYesterday []Text
+	InProgress    []Text
+	Blocked       []Text
+}
+
+type standupState struct {
+	LastPostedAt time.Time `json:"last_posted_at"`
+}
+
+// FileStandupStore stores when the standup report was last posted, so that it is posted once per schedule match
+// even when it is checked late or by several instances.
+type FileStandupStore struct {
+	Dir string
+}
+
+func (s *FileStandupStore) getPath() string {
+	return filepath.Join(s.Dir, "standup.json")
+}
+
+func (s *FileStandupStore) read() (standupState, error) {
+	var state standupState
+
+	data, err := ioutil.ReadFile(s.getPath())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = json.Unmarshal(data, &state)
+	return state, err
+}
+
+func (s *FileStandupStore) write(state standupState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.getPath(), data)
+}
+
+// ClaimIfDue records the report as posted at now if the schedule has matched since it was last posted, and
+// returns when it was last posted, to be restored with Release if posting fails. Without a schedule, whatever
+// triggers the report is its schedule, and it is due once a day.
+func (s *FileStandupStore) ClaimIfDue(schedule *CronSchedule, location *time.Location, now time.Time) (time.Time, bool, error) {
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer unlock()
+
+	state, err := s.read()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	lastPostedAt := state.LastPostedAt
+	if schedule == nil {
+		lastPostedYear, lastPostedMonth, lastPostedDay := lastPostedAt.In(location).Date()
+		year, month, day := now.In(location).Date()
+		if lastPostedYear == year && lastPostedMonth == month && lastPostedDay == day {
+			return lastPostedAt, false, nil
+		}
+
+		return lastPostedAt, true, s.write(standupState{LastPostedAt: now})
+	}
+	if lastPostedAt.IsZero() {
+		// The first check only covers the current minute, rather than posting a report missed before it was set up.
+		lastPostedAt = now.Add(-time.Minute)
+		if err := s.write(standupState{LastPostedAt: lastPostedAt}); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+
+	if !schedule.MatchesBetween(lastPostedAt.In(location), now.In(location)) {
+		return lastPostedAt, false, nil
+	}
+
+	return lastPostedAt, true, s.write(standupState{LastPostedAt: now})
+}
+
+// Release puts back when the report was last posted, unless it has been claimed again since claimedAt.
+func (s *FileStandupStore) Release(lastPostedAt time.Time, claimedAt time.Time) error {
+	unlock, err := lockDir(s.Dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := s.read()
+	if err != nil {
+		return err
+	}
+	if !state.LastPostedAt.Equal(claimedAt) {
+		return nil
+	}
+
+	return s.write(standupState{LastPostedAt: lastPostedAt})
+}
+
+// getStandupSince returns the start of the previous working day, so that Monday's standup covers Friday.
+func getStandupSince(now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	switch today.Weekday() {
+	case time.Monday:
+		return today.AddDate(0, 0, -3)
+	case time.Sunday:
+		return today.AddDate(0, 0, -2)
+	default:
+		return today.AddDate(0, 0, -1)
+	}
+}
+
+// getStandupReports queries the stories of the current iteration(s), and groups them by owner.
+func getStandupReports(clubhouseApiClient *ClubhouseApiClient, now time.Time) ([]standupReport, error) {
+	iterations, err := clubhouseApiClient.ListIterations()
+	if err != nil {
+		return nil, err
+	}
+
+	var stories []GetStoryResponse
+	for _, iteration := range iterations {
+		if iteration.Status != "started" {
+			continue
+		}
+
+		// Search matches iterations by name, so stories of other iterations with the same name are left out.
+		iterationStories, err := clubhouseApiClient.QueryStories(fmt.Sprintf("iteration:%q !is:archived", iteration.Name))
+		if err != nil {
+			return nil, err
+		}
+		for _, story := range iterationStories {
+			if story.IterationID != nil && *story.IterationID == iteration.ID {
+				stories = append(stories, story)
+			}
+		}
+	}
+
+	since := getStandupSince(now)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	reportsByOwnerID := make(map[string]*standupReport)
+
+	for _, story := range stories {
+		link := linkText(story.Name, story.AppURL)
+
+		for _, ownerID := range story.OwnerIds {
+			report, ok := reportsByOwnerID[ownerID]
+			if !ok {
+				report = &standupReport{}
+				reportsByOwnerID[ownerID] = report
+			}
+
+			switch {
+			case story.Completed:
+				if story.CompletedAt != nil && !story.CompletedAt.Before(since) && story.CompletedAt.Before(today) {
+					report.DoneYesterday = append(report.DoneYesterday, link)
+				}
+			case story.Blocked:
+				report.Blocked = append(report.Blocked, link)
+			case story.Started:
+				report.InProgress = append(report.InProgress, link)
+			}
+		}
+	}
+
+	ownerIDs := make([]string, 0, len(reportsByOwnerID))
+	for ownerID := range reportsByOwnerID {
+		ownerIDs = append(ownerIDs, ownerID)
+	}
+
+	memberNames, err := getMemberNames(clubhouseApiClient, ownerIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := make([]standupReport, len(ownerIDs))
+	for i, ownerID := range ownerIDs {
+		reports[i] = *reportsByOwnerID[ownerID]
+		reports[i].MemberName = memberNames[i]
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].MemberName < reports[j].MemberName
+	})
+
+	return reports, nil
+}
+
+func getStandupFieldValue(stories []Text) Text {
+	if len(stories) == 0 {
+		return plainText("Nothing")
+	}
+
+	return concatText(plainText("• "), joinText(stories, "\n• "))
+}
+
+// toStandupEvents renders an item per person, split across as many events as Discord's embed limit requires.
+func toStandupEvents(reports []standupReport, now time.Time) []Event {
+	var events []Event
+
+	for i, report := range reports {
+		if i%maxEmbeds == 0 {
+			events = append(events, Event{})
+		}
+
+		colour := 3447003
+		if len(report.Blocked) > 0 {
+			colour = 16065069
+		}
+
+		event := &events[len(events)-1]
+		event.Items = append(event.Items, EventItem{
+			Title:  report.MemberName,
+			Colour: colour,
+			Fields: []EventField{
+				{
+					Name:  "Done Yesterday",
+					Value: getStandupFieldValue(report.DoneYesterday),
+				},
+				{
+					Name:  "In Progress",
+					Value: getStandupFieldValue(report.InProgress),
+				},
+				{
+					Name:  "Blocked",
+					Value: getStandupFieldValue(report.Blocked),
+				},
+			},
+		})
+	}
+
+	if len(events) > 0 {
+		events[0].Content = boldText(fmt.Sprintf("Standup for %s", now.Format("Monday, January 2")))
+	}
+	for i := range events {
+		events[i].ID = fmt.Sprintf("standup:%s:%d", now.Format("2006-01-02"), i)
+	}
+
+	return events
+}
+
+func postStandup(env environment) error {
+	clubhouseApiClient := env.newClubhouseApiClient()
+	now := time.Now().In(env.StandupLocation)
+
+	reports, err := getStandupReports(clubhouseApiClient, now)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range toStandupEvents(reports, now) {
+		payload, err := json.Marshal(toDiscordWebhook(event))
+		if err != nil {
+			return err
+		}
+
+		res, err := http.Post(env.StandupWebhookURL, "application/json", bytes.NewBuffer(payload))
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		if res.StatusCode < 200 || res.StatusCode >= 300 {
+			log.Println("payload", string(payload))
+			return fmt.Errorf("unexpected status code %d", res.StatusCode)
+		}
+	}
+
+	return nil
+}
+
+// PostStandupIfDue posts the standup report if STANDUP_SCHEDULE has matched since it was last posted.
+func PostStandupIfDue() {
+	for _, env := range getEnvironments() {
+		if env.StandupSchedule == nil {
+			continue
+		}
+
+		if err := postStandupIfDue(env); err != nil {
+			log.Printf("\nfailed to post standup for %q: %s \n", env.Tenant, err)
+		}
+	}
+}
+
+func postStandupIfDue(env environment) error {
+	standupStore := &FileStandupStore{Dir: env.StandupStoreDir}
+	now := time.Now()
+
+	lastPostedAt, ok, err := standupStore.ClaimIfDue(env.StandupSchedule, env.StandupLocation, now)
+	if err != nil {
+		return fmt.Errorf("failed to check standup schedule: %v", err)
+	}
+	if !ok {
+		return nil
+	}
+
+	if err := postStandup(env); err != nil {
+		// It is retried on the next check.
+		if err := standupStore.Release(lastPostedAt, now); err != nil {
+			log.Println("failed to restore standup schedule:", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Standup is an HTTP entry point that posts the standup report, to be triggered on a schedule (e.g. by Cloud Scheduler).
+// It is posted once per match of STANDUP_SCHEDULE, or once a day without one, however often it is triggered.
+func Standup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		log.Printf("\ninvalid method: %s \n", r.Method)
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte("invalid request"))
+		return
+	}
+
+	failed := false
+	for _, env := range getEnvironments() {
+		if env.Tenant != "" && env.StandupSchedule == nil {
+			continue
+		}
+
+		if err := postStandupIfDue(env); err != nil {
+			log.Printf("\nfailed to post standup for %q: %s \n", env.Tenant, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		http.Error(w, "failed to post standup", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
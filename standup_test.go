@@ -0,0 +1,146 @@
+package function
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetStandupReports(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/iterations":
+			_, _ = w.Write([]byte(`[{"id": 1, "name": "Sprint 2", "status": "started"}, {"id": 2, "name": "Sprint 1", "status": "done"}]`))
+		case "/search/stories":
+			queries = append(queries, r.URL.Query().Get("query"))
+			if r.URL.Query().Get("next") == "" {
+				_, _ = w.Write([]byte(`{"data": [
+					{"name": "Done", "app_url": "https://example.com/1", "owner_ids": ["alice"], "iteration_id": 1, "completed": true, "completed_at": "2021-03-01T10:00:00Z"},
+					{"name": "Other iteration", "app_url": "https://example.com/2", "owner_ids": ["alice"], "iteration_id": 3, "started": true}
+				], "next": "/search/stories?next=2"}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"data": [
+				{"name": "Blocked", "app_url": "https://example.com/3", "owner_ids": ["alice"], "iteration_id": 1, "started": true, "blocked": true}
+			], "next": null}`))
+		case "/members/alice":
+			_, _ = w.Write([]byte(`{"id": "alice", "profile": {"name": "Alice"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: t.Name(), BaseURL: server.URL}
+	reports, err := getStandupReports(clubhouseApiClient, time.Date(2021, 3, 2, 9, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(queries) != 2 || queries[0] != `iteration:"Sprint 2" !is:archived` {
+		t.Errorf("queries = %q, want the started iteration's pages", queries)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("getStandupReports() = %+v, want a report for Alice", reports)
+	}
+	report := reports[0]
+	if report.MemberName != "Alice" || len(report.DoneYesterday) != 1 || len(report.Blocked) != 1 || len(report.InProgress) != 0 {
+		t.Errorf("getStandupReports() = %+v", report)
+	}
+}
+
+func TestFileStandupStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	schedule, err := ParseCronSchedule("0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &FileStandupStore{Dir: dir}
+	nine := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	if _, ok, err := store.ClaimIfDue(schedule, time.UTC, nine.Add(-time.Hour)); err != nil || ok {
+		t.Fatalf("ClaimIfDue() at 8am = %t, %v, want it not to be due", ok, err)
+	}
+
+	lastPostedAt, ok, err := store.ClaimIfDue(schedule, time.UTC, nine.Add(30*time.Second))
+	if err != nil || !ok {
+		t.Fatalf("ClaimIfDue() at 9am = %t, %v, want it to be due", ok, err)
+	}
+	if _, ok, err := store.ClaimIfDue(schedule, time.UTC, nine.Add(time.Minute)); err != nil || ok {
+		t.Fatalf("ClaimIfDue() after claiming = %t, %v, want it not to be due", ok, err)
+	}
+
+	// A failed post is retried on the next check, even though the schedule no longer matches.
+	if err := store.Release(lastPostedAt, nine.Add(30*time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := store.ClaimIfDue(schedule, time.UTC, nine.Add(2*time.Minute)); err != nil || !ok {
+		t.Errorf("ClaimIfDue() after releasing = %t, %v, want it to be due", ok, err)
+	}
+}
+
+func TestFileStandupStoreWithoutASchedule(t *testing.T) {
+	dir, err := ioutil.TempDir("", "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &FileStandupStore{Dir: dir}
+	nine := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	if _, ok, err := store.ClaimIfDue(nil, time.UTC, nine); err != nil || !ok {
+		t.Fatalf("ClaimIfDue() = %t, %v, want the first report to be due", ok, err)
+	}
+	if _, ok, err := store.ClaimIfDue(nil, time.UTC, nine.Add(time.Hour)); err != nil || ok {
+		t.Fatalf("ClaimIfDue() later that day = %t, %v, want it not to be due", ok, err)
+	}
+	if _, ok, err := store.ClaimIfDue(nil, time.UTC, nine.AddDate(0, 0, 1)); err != nil || !ok {
+		t.Errorf("ClaimIfDue() the next day = %t, %v, want it to be due", ok, err)
+	}
+}
+
+func TestStandupIsPostedOnceWhenTriggeredAgain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config := newTestConfig()
+	config.StoreDir = dir
+	defer useTestConfig(&config)()
+
+	// The report starts by listing iterations, so each request for them is an attempt to post it.
+	attempts := 0
+	defaultClubhouseHTTPClient.Transport = roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		if strings.HasSuffix(r.URL.Path, "/iterations") {
+			attempts++
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("[]")), Request: r}, nil
+	})
+	defer func() { defaultClubhouseHTTPClient.Transport = nil }()
+
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		Standup(w, httptest.NewRequest(http.MethodPost, "/standup", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Standup() = %d %q, want 200", w.Code, w.Body.String())
+		}
+	}
+
+	if attempts != 1 {
+		t.Errorf("standup was posted %d times, want 1", attempts)
+	}
+}
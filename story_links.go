@@ -0,0 +1,121 @@
+package function
+
+import (
+	"fmt"
+)
+
+// https://clubhouse.io/api/rest/v3/#Story-Link
+type storyLinkVerbs struct {
+	created string
+	deleted string
+}
+
+var storyLinkVerbsByVerb = map[string]storyLinkVerbs{
+	"blocks":     {"is now blocked by", "is no longer blocked by"},
+	"duplicates": {"is now duplicated by", "is no longer duplicated by"},
+	"relates to": {"now relates to", "no longer relates to"},
+}
+
+func findAction(webhook ClubhouseWebhook, entityType string) (ClubhouseAction, bool) {
+	for _, action := range webhook.Actions {
+		if action.EntityType == entityType {
+			return action, true
+		}
+	}
+
+	return ClubhouseAction{}, false
+}
+
+// getStory finds a story by ID in the webhook actions, then in the webhook references.
+func getStory(webhook ClubhouseWebhook, referencesByTypeID map[string]ClubhouseReference, storyID int) (string, string) {
+	for _, action := range webhook.Actions {
+		if action.EntityType == "story" && action.ID == storyID {
+			return action.Name, action.AppURL
+		}
+	}
+
+	storyTypeID := fmt.Sprintf("%s:%d", "story", storyID)
+	if story, ok := referencesByTypeID[storyTypeID]; ok {
+		return story.Name, story.AppURL
+	}
+
+	return fmt.Sprintf("#%d", storyID), ""
+}
+
+func isNewBlocker(changes ClubhouseChanges) bool {
+	return (changes.Blocker != nil && changes.Blocker.New) || (changes.Blocked != nil && changes.Blocked.New)
+}
+
+// isEscalation reports whether a webhook should be sent to the escalation channel (if one is configured):
+// a story was flagged as a blocker or blocked, or a blocking link was added. Unblocking is not escalated.
+func isEscalation(webhook ClubhouseWebhook) bool {
+	for _, action := range webhook.Actions {
+		if action.EntityType == "story-link" && action.Verb == "blocks" && action.Action == "create" {
+			return true
+		}
+
+		if action.EntityType == "story" && isNewBlocker(action.Changes) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func toStoryLinkEvent(
+	referencesByTypeID map[string]ClubhouseReference,
+	webhook ClubhouseWebhook,
+	linkAction ClubhouseAction,
+) (*Event, error) {
+	verbs, ok := storyLinkVerbsByVerb[linkAction.Verb]
+	if !ok {
+		return nil, nil
+	}
+
+	var verb string
+	var colour int
+
+	switch linkAction.Action {
+	case "create":
+		verb = verbs.created
+		colour = 5424154
+		if linkAction.Verb == "blocks" {
+			colour = 16065069
+		}
+	case "delete":
+		verb = verbs.deleted
+		colour = 16440084
+		if linkAction.Verb == "blocks" {
+			colour = 5424154
+		}
+	default:
+		return nil, nil
+	}
+
+	subjectName, subjectURL := getStory(webhook, referencesByTypeID, linkAction.SubjectID)
+	objectName, objectURL := getStory(webhook, referencesByTypeID, linkAction.ObjectID)
+
+	if objectURL == "" {
+		return nil, nil
+	}
+
+	return &Event{
+		Items: []EventItem{
+			{
+				Title:  fmt.Sprintf("Story %s %s story %s", objectName, verb, subjectName),
+				URL:    objectURL,
+				Colour: colour,
+				Fields: []EventField{
+					{
+						Name:  "Story",
+						Value: linkText(objectName, objectURL),
+					},
+					{
+						Name:  "Linked Story",
+						Value: linkText(subjectName, subjectURL),
+					},
+				},
+			},
+		},
+	}, nil
+}
@@ -0,0 +1,36 @@
+package function
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestIsEscalation(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions string
+		want    bool
+	}{
+		{"blocking link added", `[{"entity_type": "story-link", "action": "create", "verb": "blocks"}]`, true},
+		{"blocking link deleted", `[{"entity_type": "story-link", "action": "delete", "verb": "blocks"}]`, false},
+		{"related link added", `[{"entity_type": "story-link", "action": "create", "verb": "relates to"}]`, false},
+		{"blocker flagged", `[{"entity_type": "story", "action": "update", "changes": {"blocker": {"old": false, "new": true}}}]`, true},
+		{"blocked flagged", `[{"entity_type": "story", "action": "update", "changes": {"blocked": {"old": false, "new": true}}}]`, true},
+		{"blocker cleared", `[{"entity_type": "story", "action": "update", "changes": {"blocker": {"old": true, "new": false}}}]`, false},
+		{"blocked cleared", `[{"entity_type": "story", "action": "update", "changes": {"blocked": {"old": true, "new": false}}}]`, false},
+		{"other change", `[{"entity_type": "story", "action": "update", "changes": {"estimate": {"old": 1, "new": 2}}}]`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var webhook ClubhouseWebhook
+			if err := json.Unmarshal([]byte(`{"actions": `+test.actions+`}`), &webhook); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := isEscalation(webhook); got != test.want {
+				t.Errorf("isEscalation() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package function
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Hosts of Teams incoming webhooks, and of Workflows (Power Automate) webhooks.
+var teamsWebhookHostSuffixes = []string{".webhook.office.com", ".logic.azure.com", ".powerplatform.com"}
+
+// https://learn.microsoft.com/en-us/microsoftteams/platform/webhooks-and-connectors/how-to/connectors-using#send-adaptive-cards-using-an-incoming-webhook
+type TeamsMessage struct {
+	Type        string            `json:"type"`
+	Attachments []TeamsAttachment `json:"attachments"`
+}
+
+type TeamsAttachment struct {
+	ContentType string       `json:"contentType"`
+	Content     AdaptiveCard `json:"content"`
+}
+
+// https://adaptivecards.io/explorer/AdaptiveCard.html
+type AdaptiveCard struct {
+	Schema  string                 `json:"$schema"`
+	Type    string                 `json:"type"`
+	Version string                 `json:"version"`
+	Body    []AdaptiveCardElement  `json:"body"`
+	MSTeams map[string]interface{} `json:"msteams,omitempty"`
+}
+
+// An element of any type, with only the properties of its type set.
+// https://adaptivecards.io/explorer/
+type AdaptiveCardElement struct {
+	Type    string                `json:"type"`
+	Text    string                `json:"text,omitempty"`
+	Weight  string                `json:"weight,omitempty"`
+	Size    string                `json:"size,omitempty"`
+	Wrap    bool                  `json:"wrap,omitempty"`
+	Style   string                `json:"style,omitempty"`
+	Items   []AdaptiveCardElement `json:"items,omitempty"`
+	Facts   []AdaptiveCardFact    `json:"facts,omitempty"`
+	Actions []AdaptiveCardAction  `json:"actions,omitempty"`
+}
+
+type AdaptiveCardFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+type AdaptiveCardAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	URL   string `json:"url"`
+}
+
+// TeamsSink posts events to a Microsoft Teams incoming webhook (or a Workflows webhook) as Adaptive Cards.
+type TeamsSink struct {
+	SinkName   string
+	WebhookURL string
+}
+
+func (s *TeamsSink) Name() string {
+	return s.SinkName
+}
+
+func (s *TeamsSink) Send(ctx context.Context, event Event) error {
+	return postJSON(ctx, s.WebhookURL, toTeamsMessage(event))
+}
+
+func validateTeamsWebhookURL(webhookURL string) error {
+	parsedURL, err := url.Parse(webhookURL)
+	if err != nil {
+		return fmt.Errorf("must be a URL: %v", err)
+	}
+
+	if parsedURL.Scheme == "https" {
+		for _, suffix := range teamsWebhookHostSuffixes {
+			if strings.HasSuffix(parsedURL.Hostname(), suffix) {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("must be a Teams incoming webhook (https://*.webhook.office.com/...) or Workflows URL, got a URL to %s", parsedURL.Host)
+}
+
+func toTeamsMessage(event Event) TeamsMessage {
+	card := AdaptiveCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		MSTeams: map[string]interface{}{"width": "Full"},
+	}
+
+	if len(event.Content) > 0 {
+		card.Body = append(card.Body, AdaptiveCardElement{Type: "TextBlock", Text: toTeamsMarkdown(event.Content), Wrap: true})
+	}
+
+	for _, item := range event.Items {
+		items := []AdaptiveCardElement{
+			{Type: "TextBlock", Text: item.Title, Weight: "Bolder", Size: "Medium", Wrap: true},
+		}
+
+		if item.Description != "" {
+			items = append(items, AdaptiveCardElement{Type: "TextBlock", Text: item.Description, Wrap: true})
+		}
+
+		if len(item.Fields) > 0 {
+			factSet := AdaptiveCardElement{Type: "FactSet"}
+			for _, field := range item.Fields {
+				factSet.Facts = append(factSet.Facts, AdaptiveCardFact{
+					Title: field.Name,
+					Value: toTeamsMarkdown(field.Value),
+				})
+			}
+			items = append(items, factSet)
+		}
+
+		if item.URL != "" {
+			items = append(items, AdaptiveCardElement{
+				Type: "ActionSet",
+				Actions: []AdaptiveCardAction{
+					{Type: "Action.OpenUrl", Title: "Open in Clubhouse", URL: item.URL},
+				},
+			})
+		}
+
+		card.Body = append(card.Body, AdaptiveCardElement{
+			Type:  "Container",
+			Style: getTeamsContainerStyle(item.Colour),
+			Items: items,
+		})
+	}
+
+	return TeamsMessage{
+		Type: "message",
+		Attachments: []TeamsAttachment{
+			{ContentType: "application/vnd.microsoft.card.adaptive", Content: card},
+		},
+	}
+}
+
+// getTeamsContainerStyle approximates an embed colour, as Adaptive Cards only have a few named styles.
+// https://adaptivecards.io/explorer/Container.html
+func getTeamsContainerStyle(colour int) string {
+	red, green, blue := colour>>16&0xff, colour>>8&0xff, colour&0xff
+
+	switch {
+	case colour == 0:
+		return "default"
+	case red > 200 && green < 100:
+		return "attention"
+	case red > 200 && green > 100 && blue < 100:
+		return "warning"
+	case green > red && green > blue:
+		return "good"
+	case blue > red && blue > green:
+		return "accent"
+	default:
+		return "emphasis"
+	}
+}
+
+// toTeamsMarkdown writes text in the markdown of Adaptive Cards, which has no code spans, with timestamps as date
+// functions. Adaptive Cards have no relative dates, so those are shown as dates too.
+// https://learn.microsoft.com/en-us/adaptive-cards/authoring-cards/text-features
+func toTeamsMarkdown(text Text) string {
+	return text.format(textFormat{
+		plain: func(text string) string { return text },
+		bold:  func(text string) string { return "**" + text + "**" },
+		code:  func(text string) string { return text },
+		link:  func(text string, url string) string { return fmt.Sprintf("[%s](%s)", text, url) },
+		time: func(kind TextSpanKind, t time.Time) string {
+			iso := t.Format("2006-01-02T15:04:05Z")
+			if kind == TextSpanKind_DateTime {
+				return fmt.Sprintf("{{DATE(%[1]s, SHORT)}} {{TIME(%[1]s)}}", iso)
+			}
+			return fmt.Sprintf("{{DATE(%s, SHORT)}}", iso)
+		},
+	})
+}
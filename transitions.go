@@ -0,0 +1,143 @@
+package function
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// getTransitionVerb describes archive / start / completion transitions, or returns "" if there are none.
+func getTransitionVerb(changes ClubhouseChanges) string {
+	switch {
+	case changes.Archived != nil && changes.Archived.New:
+		return "archived"
+	case changes.Archived != nil && !changes.Archived.New:
+		return "unarchived"
+	case changes.Completed != nil && changes.Completed.New:
+		return "completed"
+	case changes.Completed != nil && !changes.Completed.New:
+		return "reopened"
+	case changes.Started != nil && changes.Started.New:
+		return "started"
+	default:
+		return ""
+	}
+}
+
+func getTransitionFields(changes ClubhouseChanges) []EventField {
+	var fields []EventField
+
+	if changes.Archived != nil {
+		archivedValue := "📦 Archived"
+		if !changes.Archived.New {
+			archivedValue = "Restored from the archive"
+		}
+		fields = append(fields, EventField{
+			Name:  "Archived",
+			Value: plainText(archivedValue),
+		})
+	}
+
+	if changes.Started != nil {
+		startedValue := "Moved back to not started"
+		if changes.Started.New {
+			startedValue = "▶️ Work started"
+			if changes.StartedAt != nil {
+				startedValue = fmt.Sprintf("%s on %s", startedValue, changes.StartedAt.New.Format("Jan 2, 2006"))
+			}
+		}
+		fields = append(fields, EventField{
+			Name:   "Started",
+			Value:  plainText(startedValue),
+			Inline: true,
+		})
+	}
+
+	if changes.Completed != nil {
+		completedValue := "Reopened"
+		if changes.Completed.New {
+			completedValue = "✅ Done"
+			if changes.CompletedAt != nil {
+				completedValue = fmt.Sprintf("%s on %s", completedValue, changes.CompletedAt.New.Format("Jan 2, 2006"))
+			}
+		}
+		fields = append(fields, EventField{
+			Name:   "Completed",
+			Value:  plainText(completedValue),
+			Inline: true,
+		})
+	}
+
+	return fields
+}
+
+// getCycleTimeField returns the time taken from a story being started to it being completed.
+func getCycleTimeField(clubhouseApiClient *ClubhouseApiClient, action ClubhouseAction) (*EventField, error) {
+	changes := action.Changes
+	if action.EntityType != "story" || changes.Completed == nil || !changes.Completed.New || changes.CompletedAt == nil {
+		return nil, nil
+	}
+
+	var startedAt time.Time
+	if changes.StartedAt != nil {
+		startedAt = changes.StartedAt.New
+	} else {
+		story, err := clubhouseApiClient.GetStory(action.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if story.StartedAt == nil {
+			return nil, nil
+		}
+		startedAt = *story.StartedAt
+	}
+
+	cycleTime := changes.CompletedAt.New.Sub(startedAt)
+	if cycleTime < 0 {
+		return nil, nil
+	}
+
+	return &EventField{
+		Name:   "Cycle Time",
+		Value:  plainText(formatDuration(cycleTime)),
+		Inline: true,
+	}, nil
+}
+
+// formatDuration formats a duration using its two largest units, e.g. "3 days, 4 hours".
+func formatDuration(duration time.Duration) string {
+	units := []struct {
+		name   string
+		length time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+
+	var parts []string
+	for _, unit := range units {
+		count := int(duration / unit.length)
+		if count == 0 {
+			continue
+		}
+		duration -= time.Duration(count) * unit.length
+
+		if count == 1 {
+			parts = append(parts, fmt.Sprintf("1 %s", unit.name))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d %ss", count, unit.name))
+		}
+
+		if len(parts) == 2 {
+			break
+		}
+	}
+
+	if len(parts) == 0 {
+		return "Less than a minute"
+	}
+
+	return strings.Join(parts, ", ")
+}
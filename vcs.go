@@ -0,0 +1,202 @@
+package function
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// https://help.clubhouse.io/hc/en-us/articles/207540323-Using-The-Clubhouse-GitHub-Integration
+var vcsEntityNames = map[string]string{
+	"branch":       "Branch",
+	"commit":       "Commit",
+	"pull-request": "PR",
+}
+
+func isVCSEntityType(entityType string) bool {
+	_, ok := vcsEntityNames[entityType]
+	return ok
+}
+
+// splitVCSActions separates the VCS (branch, commit, pull request) actions of a webhook
+// from the story actions that Clubhouse sends alongside them.
+func splitVCSActions(webhook ClubhouseWebhook) ([]ClubhouseAction, []ClubhouseAction) {
+	var vcsActions []ClubhouseAction
+	var storyActions []ClubhouseAction
+
+	for _, action := range webhook.Actions {
+		if isVCSEntityType(action.EntityType) {
+			vcsActions = append(vcsActions, action)
+		} else if action.EntityType == "story" {
+			storyActions = append(storyActions, action)
+		}
+	}
+
+	return vcsActions, storyActions
+}
+
+func findStoryStateChange(storyActions []ClubhouseAction) (ClubhouseAction, bool) {
+	for _, action := range storyActions {
+		if action.Changes.WorkflowStateID != nil {
+			return action, true
+		}
+	}
+
+	return ClubhouseAction{}, false
+}
+
+func getVCSVerb(action ClubhouseAction) string {
+	switch action.EntityType {
+	case "pull-request":
+		switch {
+		case action.Action == "create":
+			return "opened"
+		case action.Changes.Merged != nil && action.Changes.Merged.New:
+			return "merged"
+		case action.Changes.Closed != nil && action.Changes.Closed.New:
+			return "closed"
+		case action.Changes.Closed != nil && !action.Changes.Closed.New:
+			return "reopened"
+		}
+	case "commit":
+		if action.Action == "create" {
+			return "pushed"
+		}
+	}
+
+	return action.Action + "d"
+}
+
+func getVCSColour(verb string) int {
+	switch verb {
+	case "opened", "created", "pushed", "reopened":
+		return 5424154
+	case "merged":
+		return 7291585
+	case "closed", "deleted":
+		return 16065069
+	default:
+		return 16440084
+	}
+}
+
+func toVCSEvent(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	webhook ClubhouseWebhook,
+	vcsAction ClubhouseAction,
+	storyActions []ClubhouseAction,
+	options DiscordOptions,
+) (*Event, error) {
+	subject := vcsEntityNames[vcsAction.EntityType]
+	var description string
+
+	switch vcsAction.EntityType {
+	case "pull-request":
+		subject = fmt.Sprintf("%s #%d", subject, vcsAction.Number)
+		description = vcsAction.Title
+	case "branch":
+		subject = fmt.Sprintf("%s %s", subject, vcsAction.Name)
+	case "commit":
+		hash := vcsAction.Hash
+		if len(hash) > 7 {
+			hash = hash[:7]
+		}
+		subject = strings.TrimSpace(fmt.Sprintf("%s %s", subject, hash))
+		description = strings.SplitN(vcsAction.Message, "\n", 2)[0]
+	}
+
+	verb := getVCSVerb(vcsAction)
+	webhookTitle := fmt.Sprintf("%s %s", subject, verb)
+
+	var fields []EventField
+
+	if len(storyActions) == 1 {
+		webhookTitle = fmt.Sprintf("%s for story: %s", webhookTitle, storyActions[0].Name)
+	} else if len(storyActions) > 1 {
+		stories := make([]Text, len(storyActions))
+		for i, storyAction := range storyActions {
+			stories[i] = linkText(storyAction.Name, storyAction.AppURL)
+		}
+		fields = append(fields, EventField{
+			Name:  "Stories",
+			Value: joinText(stories, "\n"),
+		})
+	}
+
+	if vcsAction.RepositoryID > 0 {
+		// Webhooks do not always include the repository in their references.
+		repository, err := resolveReference(clubhouseApiClient, referencesByTypeID, "repository", vcsAction.RepositoryID)
+		if err != nil {
+			log.Printf("failed to resolve repository: %v", err)
+		} else {
+			fields = append(fields, EventField{
+				Name:   "Repository",
+				Value:  plainText(repository.Name),
+				Inline: true,
+			})
+		}
+	}
+
+	authorID := vcsAction.AuthorID
+	if authorID == "" {
+		authorID = webhook.MemberID
+	}
+	if authorID != "" {
+		member, err := clubhouseApiClient.GetMember(authorID)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, EventField{
+			Name:   "Author",
+			Value:  plainText(member.Profile.Name),
+			Inline: true,
+		})
+	}
+
+	if vcsAction.BranchName != "" && vcsAction.TargetBranchName != "" {
+		fields = append(fields, EventField{
+			Name:   "Branch",
+			Value:  plainText(fmt.Sprintf("%s -> %s", vcsAction.BranchName, vcsAction.TargetBranchName)),
+			Inline: true,
+		})
+	}
+
+	for _, storyAction := range storyActions {
+		if storyAction.Changes.WorkflowStateID == nil {
+			continue
+		}
+
+		stateFields, err := getChangesFields(
+			clubhouseApiClient,
+			referencesByTypeID,
+			ClubhouseChanges{WorkflowStateID: storyAction.Changes.WorkflowStateID},
+			options,
+		)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, stateFields...)
+	}
+
+	webhookURL := vcsAction.URL
+	if webhookURL == "" && len(storyActions) > 0 {
+		webhookURL = storyActions[0].AppURL
+	}
+
+	if webhookURL == "" {
+		return nil, nil
+	}
+
+	return &Event{
+		Items: []EventItem{
+			{
+				Title:       webhookTitle,
+				URL:         webhookURL,
+				Description: description,
+				Colour:      getVCSColour(verb),
+				Fields:      fields,
+			},
+		},
+	}, nil
+}
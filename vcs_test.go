@@ -0,0 +1,156 @@
+package function
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestToVCSEvent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repositories/8" {
+			_, _ = w.Write([]byte(`{"id": 8, "name": "courtsite/api", "url": "https://github.com/courtsite/api"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": "alice", "profile": {"name": "Alice"}}`))
+	}))
+	defer server.Close()
+
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: t.Name(), BaseURL: server.URL}
+
+	references := `"references": [
+		{"id": 7, "entity_type": "repository", "name": "courtsite/web"},
+		{"id": 500, "entity_type": "workflow-state", "name": "In Review"},
+		{"id": 501, "entity_type": "workflow-state", "name": "Done"}
+	]`
+	login := `{"id": 1, "entity_type": "story", "action": "update", "name": "Login", "app_url": "https://app.clubhouse.io/workspace/story/1"}`
+	loginDone := `{"id": 1, "entity_type": "story", "action": "update", "name": "Login", "app_url": "https://app.clubhouse.io/workspace/story/1", "changes": {"workflow_state_id": {"old": 500, "new": 501}}}`
+	signup := `{"id": 2, "entity_type": "story", "action": "update", "name": "Signup", "app_url": "https://app.clubhouse.io/workspace/story/2"}`
+
+	tests := []struct {
+		name        string
+		actions     string
+		title       string
+		description string
+		colour      int
+		fields      map[string]string
+	}{
+		{
+			"pull request opened",
+			`[{"id": 10, "entity_type": "pull-request", "action": "create", "number": 12, "title": "Fix the login page", "url": "https://github.com/courtsite/web/pull/12", "repository_id": 7, "branch_name": "fix-login", "target_branch_name": "main"}, ` + login + `]`,
+			"PR #12 opened for story: Login",
+			"Fix the login page",
+			5424154,
+			map[string]string{"Repository": "courtsite/web", "Author": "Alice", "Branch": "fix-login -> main"},
+		},
+		{
+			"repository not in the references",
+			`[{"id": 10, "entity_type": "pull-request", "action": "create", "number": 3, "title": "Add an endpoint", "url": "https://github.com/courtsite/api/pull/3", "repository_id": 8}, ` + login + `]`,
+			"PR #3 opened for story: Login",
+			"Add an endpoint",
+			5424154,
+			map[string]string{"Repository": "courtsite/api", "Author": "Alice"},
+		},
+		{
+			"pull request merged for several stories",
+			`[{"id": 10, "entity_type": "pull-request", "action": "update", "number": 12, "url": "https://github.com/courtsite/web/pull/12", "changes": {"merged": {"old": false, "new": true}}}, ` + login + `, ` + signup + `]`,
+			"PR #12 merged",
+			"",
+			7291585,
+			map[string]string{
+				"Stories": "Login (https://app.clubhouse.io/workspace/story/1)\nSignup (https://app.clubhouse.io/workspace/story/2)",
+				"Author":  "Alice",
+			},
+		},
+		{
+			"commit pushed",
+			`[{"id": 11, "entity_type": "commit", "action": "create", "hash": "0123456789abcdef", "message": "Fix the login page\n\nDetails", "url": "https://github.com/courtsite/web/commit/0123456"}, ` + login + `]`,
+			"Commit 0123456 pushed for story: Login",
+			"Fix the login page",
+			5424154,
+			map[string]string{"Author": "Alice"},
+		},
+		{
+			"merge moving the story",
+			`[{"id": 10, "entity_type": "pull-request", "action": "update", "number": 12, "url": "https://github.com/courtsite/web/pull/12", "changes": {"merged": {"old": false, "new": true}}}, ` + loginDone + `]`,
+			"PR #12 merged for story: Login",
+			"",
+			7291585,
+			map[string]string{"Author": "Alice", "State": "In Review -> Done"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var webhook ClubhouseWebhook
+			if err := json.Unmarshal([]byte(`{"member_id": "alice", "actions": `+test.actions+`, `+references+`}`), &webhook); err != nil {
+				t.Fatal(err)
+			}
+
+			event, err := toEvent(clubhouseApiClient, webhook, DiscordOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if event == nil || len(event.Items) != 1 {
+				t.Fatalf("toEvent() = %+v, want one item", event)
+			}
+
+			item := event.Items[0]
+			if item.Title != test.title || item.Description != test.description || item.Colour != test.colour {
+				t.Errorf("item = %q, %q, %d, want %q, %q, %d", item.Title, item.Description, item.Colour, test.title, test.description, test.colour)
+			}
+
+			fields := make(map[string]string)
+			for _, field := range item.Fields {
+				fields[field.Name] = field.Value.String()
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("fields = %v, want %v", fields, test.fields)
+			}
+		})
+	}
+}
+
+func TestSuppressVCSStateChanges(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id": "alice", "profile": {"name": "Alice"}}`))
+	}))
+	defer server.Close()
+
+	clubhouseApiClient := &ClubhouseApiClient{ApiToken: t.Name(), BaseURL: server.URL}
+
+	data := `{
+		"member_id": "alice",
+		"actions": [
+			{"id": 10, "entity_type": "pull-request", "action": "update", "number": 12, "url": "https://github.com/courtsite/web/pull/12", "changes": {"merged": {"old": false, "new": true}}},
+			{"id": 1, "entity_type": "story", "action": "update", "name": "Login", "app_url": "https://app.clubhouse.io/workspace/story/1", "changes": {"workflow_state_id": {"old": 500, "new": 501}}}
+		],
+		"references": [
+			{"id": 500, "entity_type": "workflow-state", "name": "In Review"},
+			{"id": 501, "entity_type": "workflow-state", "name": "Done"}
+		]
+	}`
+	var webhook ClubhouseWebhook
+	if err := json.Unmarshal([]byte(data), &webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	event, err := toEvent(clubhouseApiClient, webhook, DiscordOptions{SuppressVCSStateChanges: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event == nil || len(event.Items) != 1 {
+		t.Fatalf("toEvent() = %+v, want one item", event)
+	}
+
+	// Only the story's state change is posted, as the pull request only mirrors it.
+	item := event.Items[0]
+	if item.URL != "https://app.clubhouse.io/workspace/story/1" {
+		t.Errorf("URL = %q, want the story", item.URL)
+	}
+	if len(item.Fields) != 1 || item.Fields[0].Name != "State" || item.Fields[0].Value.String() != "In Review -> Done" {
+		t.Errorf("fields = %+v, want the state change", item.Fields)
+	}
+}
@@ -0,0 +1,128 @@
+package function
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// How a workflow state is rendered. Color is a hex colour, e.g. "#49a940".
+type WorkflowStateStyle struct {
+	Icon  string `json:"icon,omitempty"`
+	Color string `json:"color,omitempty"`
+}
+
+// Styles by workflow state type.
+var defaultWorkflowStateStyles = map[string]WorkflowStateStyle{
+	"unstarted": {Icon: "⚪", Color: "#95a5a6"},
+	"started":   {Icon: "🔵", Color: "#3498db"},
+	"done":      {Icon: "✅", Color: "#52c41a"},
+}
+
+// workflowCache holds the workspace's workflows, which are needed to tell whether there is more than one.
+var workflowCache = newWorkspaceCache()
+
+// normalizeWorkflowStateStyles validates overrides keyed by workflow state name (e.g. "In Review") or type
+// (e.g. "done"), and lower cases their keys.
+func normalizeWorkflowStateStyles(styles map[string]WorkflowStateStyle) (map[string]WorkflowStateStyle, error) {
+	if len(styles) == 0 {
+		return nil, nil
+	}
+
+	stylesByKey := make(map[string]WorkflowStateStyle, len(styles))
+	for key, style := range styles {
+		if style.Color != "" {
+			if _, ok := parseLabelColour(style.Color); !ok {
+				return nil, fmt.Errorf("invalid colour for %q: %q", key, style.Color)
+			}
+		}
+		stylesByKey[strings.ToLower(key)] = style
+	}
+
+	return stylesByKey, nil
+}
+
+func getWorkflows(clubhouseApiClient *ClubhouseApiClient) ([]GetWorkflowResponse, error) {
+	workflows, err := workflowCache.get(clubhouseApiClient, func() (interface{}, error) {
+		return clubhouseApiClient.ListWorkflows()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return workflows.([]GetWorkflowResponse), nil
+}
+
+// getWorkflowStateStyle picks the style for a state by its name, then its type, falling back to the type defaults.
+func getWorkflowStateStyle(workflowState ClubhouseReference, options DiscordOptions) WorkflowStateStyle {
+	stateType := strings.ToLower(workflowState.Type)
+	style := defaultWorkflowStateStyles[stateType]
+
+	for _, key := range []string{stateType, strings.ToLower(workflowState.Name)} {
+		override, ok := options.WorkflowStateStyles[key]
+		if !ok {
+			continue
+		}
+		if override.Icon != "" {
+			style.Icon = override.Icon
+		}
+		if override.Color != "" {
+			style.Color = override.Color
+		}
+	}
+
+	return style
+}
+
+// getWorkflowStateColour returns the embed colour for a workflow state, if it has one.
+func getWorkflowStateColour(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	workflowStateID int,
+	options DiscordOptions,
+) (int, bool) {
+	workflowState, err := resolveReference(clubhouseApiClient, referencesByTypeID, "workflow-state", workflowStateID)
+	if err != nil {
+		log.Println("failed to resolve workflow-state:", err)
+		return 0, false
+	}
+
+	return parseLabelColour(getWorkflowStateStyle(workflowState, options).Color)
+}
+
+// getWorkflowStateValue renders a workflow state with its icon, and its workflow when the workspace has several.
+func getWorkflowStateValue(
+	clubhouseApiClient *ClubhouseApiClient,
+	referencesByTypeID map[string]ClubhouseReference,
+	workflowStateID int,
+	options DiscordOptions,
+) string {
+	workflowState, err := resolveReference(clubhouseApiClient, referencesByTypeID, "workflow-state", workflowStateID)
+	if err != nil {
+		log.Println("failed to resolve workflow-state:", err)
+		return fmt.Sprintf("#%d", workflowStateID)
+	}
+
+	value := strings.Title(workflowState.Name)
+	if icon := getWorkflowStateStyle(workflowState, options).Icon; icon != "" {
+		value = icon + " " + value
+	}
+
+	workflows, err := getWorkflows(clubhouseApiClient)
+	if err != nil {
+		log.Println("failed to list workflows:", err)
+		return value
+	}
+
+	if len(workflows) > 1 {
+		for _, workflow := range workflows {
+			for _, state := range workflow.States {
+				if state.ID == workflowStateID {
+					return fmt.Sprintf("%s (%s)", value, workflow.Name)
+				}
+			}
+		}
+	}
+
+	return value
+}